@@ -0,0 +1,131 @@
+////////////////////////////////
+package lyncs
+
+import (
+    "fmt"
+    "slices"
+    "sort"
+    "strings"
+)
+
+////////////////////////////////
+var abiTypeMap = map[string]func(string) (bool){
+    "string": func(v string) (bool) {
+        return true
+    },
+    "int": func(v string) (bool) {
+        return abiIsDigits(strings.TrimPrefix(v, "-"))
+    },
+    "uint": abiIsDigits,
+    "hex": func(v string) (bool) {
+        if len(v) % 2 != 0 {
+            return false
+        }
+        for i := 0; i < len(v); i ++ {
+            c := v[i]
+            if !(c >= '0' && c <= '9' || c >= 'a' && c <= 'f' || c >= 'A' && c <= 'F') {
+                return false
+            }
+        }
+        return true
+    },
+    "bool": func(v string) (bool) {
+        return v == "true" || v == "false"
+    },
+}
+
+////////////////////////////////
+var abiReturnList = []string{"op", "opParams", "opRules", "keyRules", "state", "exData"}
+
+////////////////////////////////
+func abiIsDigits(v string) (bool) {
+    if len(v) == 0 {
+        return false
+    }
+    for i := 0; i < len(v); i ++ {
+        if v[i] < '0' || v[i] > '9' {
+            return false
+        }
+    }
+    return true
+}
+
+////////////////////////////////
+func abiParseType(t string) (string, bool) {
+    if strings.HasSuffix(t, "?") {
+        return t[:len(t)-1], true
+    }
+    return t, false
+}
+
+////////////////////////////////
+func abiVerify(abi AbiType) (error) {
+    for fn, fnAbi := range abi {
+        if fnAbi == nil {
+            return fmt.Errorf("nil abi:%s @abiVerify", fn)
+        }
+        for k, t := range fnAbi.Params {
+            t, _ = abiParseType(t)
+            if abiTypeMap[t] == nil {
+                return fmt.Errorf("unknown type:%s.%s @abiVerify", fn, k)
+            }
+        }
+        for _, k := range fnAbi.Returns {
+            if !slices.Contains(abiReturnList, k) {
+                return fmt.Errorf("unknown return:%s.%s @abiVerify", fn, k)
+            }
+        }
+    }
+    return nil
+}
+
+////////////////////////////////
+func abiCheckParams(fnAbi *AbiFuncType, opParams map[string]string) (error) {
+    keys := make([]string, 0, len(opParams))
+    for k := range opParams {
+        keys = append(keys, k)
+    }
+    sort.Strings(keys)
+    for _, k := range keys {
+        v := opParams[k]
+        t, exists := fnAbi.Params[k]
+        if !exists {
+            return fmt.Errorf("unknown opParams:%s @abiCheckParams", k)
+        }
+        t, _ = abiParseType(t)
+        if !abiTypeMap[t](v) {
+            return fmt.Errorf("invalid opParams:%s @abiCheckParams", k)
+        }
+    }
+    keys = keys[:0]
+    for k := range fnAbi.Params {
+        keys = append(keys, k)
+    }
+    sort.Strings(keys)
+    for _, k := range keys {
+        _, optional := abiParseType(fnAbi.Params[k])
+        if _, exists := opParams[k]; !exists && !optional {
+            return fmt.Errorf("missing opParams:%s @abiCheckParams", k)
+        }
+    }
+    return nil
+}
+
+////////////////////////////////
+func abiCheckResult(fnAbi *AbiFuncType, result *DataResultType) (error) {
+    // Absent or empty returns means no restriction, empty tables are not counted as returned.
+    if len(fnAbi.Returns) == 0 {
+        return nil
+    }
+    allowed := make(map[string]bool, len(fnAbi.Returns))
+    for _, k := range fnAbi.Returns {
+        allowed[k] = true
+    }
+    returned := []bool{len(result.Op) > 0, len(result.OpParams) > 0, len(result.OpRules) > 0, len(result.KeyRules) > 0, len(result.StateTree) > 0, len(result.ExData) > 0}
+    for i, k := range abiReturnList {
+        if returned[i] && !allowed[k] {
+            return fmt.Errorf("unexpected return:%s @abiCheckResult", k)
+        }
+    }
+    return nil
+}
@@ -0,0 +1,144 @@
+////////////////////////////////
+package lyncs
+
+import (
+    "strings"
+    "testing"
+)
+
+////////////////////////////////
+func TestAbiCheckParams(t *testing.T) {
+    fnAbi := &AbiFuncType{Params: map[string]string{"u": "uint", "i": "int?", "s": "string?", "b": "bool?", "h": "hex?"}}
+    cases := []struct {
+        params map[string]string
+        err string
+    }{
+        {map[string]string{"u": "0"}, ""},
+        {map[string]string{"u": "18446744073709551616"}, ""},
+        {map[string]string{"u": ""}, "invalid opParams:u"},
+        {map[string]string{"u": "-1"}, "invalid opParams:u"},
+        {map[string]string{"u": "1.5"}, "invalid opParams:u"},
+        {map[string]string{"u": " 1"}, "invalid opParams:u"},
+        {map[string]string{"u": "1", "i": "-12"}, ""},
+        {map[string]string{"u": "1", "i": "12"}, ""},
+        {map[string]string{"u": "1", "i": "-"}, "invalid opParams:i"},
+        {map[string]string{"u": "1", "i": "+1"}, "invalid opParams:i"},
+        {map[string]string{"u": "1", "s": ""}, ""},
+        {map[string]string{"u": "1", "s": "a\x00b"}, ""},
+        {map[string]string{"u": "1", "b": "true"}, ""},
+        {map[string]string{"u": "1", "b": "false"}, ""},
+        {map[string]string{"u": "1", "b": "1"}, "invalid opParams:b"},
+        {map[string]string{"u": "1", "b": "True"}, "invalid opParams:b"},
+        {map[string]string{"u": "1", "h": "0aFf"}, ""},
+        {map[string]string{"u": "1", "h": "abc"}, "invalid opParams:h"},
+        {map[string]string{"u": "1", "h": "zz"}, "invalid opParams:h"},
+        {map[string]string{}, "missing opParams:u"},
+        {map[string]string{"u": "1", "x": "1"}, "unknown opParams:x"},
+    }
+    for _, c := range cases {
+        err := abiCheckParams(fnAbi, c.params)
+        if c.err == "" && err != nil || c.err != "" && (err == nil || !strings.Contains(err.Error(), c.err)) {
+            t.Errorf("%q: expected %q, got %v", c.params, c.err, err)
+        }
+    }
+}
+
+////////////////////////////////
+func TestAbiCheckResult(t *testing.T) {
+    rules := map[string]string{"k": "w"}
+    state := DataStateType{"k": "v"}
+    cases := []struct {
+        returns []string
+        result *DataResultType
+        err string
+    }{
+        {nil, &DataResultType{KeyRules: rules, StateTree: state}, ""},
+        {[]string{}, &DataResultType{KeyRules: rules, StateTree: state}, ""},
+        {[]string{"state", "keyRules"}, &DataResultType{KeyRules: rules, StateTree: state}, ""},
+        {[]string{"state"}, &DataResultType{KeyRules: rules, StateTree: state}, "unexpected return:keyRules"},
+        {[]string{"keyRules"}, &DataResultType{KeyRules: rules, StateTree: state}, "unexpected return:state"},
+        {[]string{"keyRules"}, &DataResultType{KeyRules: rules, StateTree: DataStateType{}}, ""},
+        {[]string{"op"}, &DataResultType{Op: map[string]string{}, ExData: map[string]string{}}, ""},
+        {[]string{"op"}, &DataResultType{ExData: map[string]string{"a": "b"}}, "unexpected return:exData"},
+        {[]string{"opParams", "opRules"}, &DataResultType{OpParams: rules, OpRules: rules}, ""},
+        {[]string{"opParams"}, &DataResultType{OpParams: rules, OpRules: rules}, "unexpected return:opRules"},
+    }
+    for i, c := range cases {
+        err := abiCheckResult(&AbiFuncType{Returns: c.returns}, c.result)
+        if c.err == "" && err != nil || c.err != "" && (err == nil || !strings.Contains(err.Error(), c.err)) {
+            t.Errorf("case %d: expected %q, got %v", i, c.err, err)
+        }
+    }
+}
+
+////////////////////////////////
+func TestAbiVerify(t *testing.T) {
+    cases := []struct {
+        code string
+        err string
+    }{
+        {`abi = {run = {params = {n = "uint"}, returns = {}}}`, ""},
+        {`abi = {run = {params = {n = "uint?"}, returns = {"state", "keyRules"}}}`, ""},
+        {`abi = {run = {params = {n = "float"}}}`, "unknown type:run.n"},
+        {`abi = {run = {returns = {"logs"}}}`, "unknown return:run.logs"},
+        {`abi = {run = 1}`, "invalid abi"},
+        {`abi = {run = {params = {n = 1}}}`, "invalid params type"},
+        {`abi = {run = {params = {"uint"}}}`, "invalid params type"},
+        {`abi = {run = {params = "uint"}}`, "invalid params"},
+        {`abi = {run = {returns = {state = true}}}`, "invalid returns type"},
+    }
+    for _, c := range cases {
+        s, _, err := stateFromCode(c.code + "\nfunction init() return {} end\nfunction run() return {} end\n")
+        if err != nil {
+            t.Fatal(err)
+        }
+        _, err = stateGetAbi(s)
+        stateClose(s)
+        if c.err == "" && err != nil || c.err != "" && (err == nil || !strings.Contains(err.Error(), c.err)) {
+            t.Errorf("%s: expected %q, got %v", c.code, c.err, err)
+        }
+    }
+}
+
+////////////////////////////////
+func TestAbiEmptyReturns(t *testing.T) {
+    code := `
+abi = {run = {params = {n = "uint"}, returns = {}}}
+function init() return {} end
+function run()
+  return {state = {count = session.opParams.n}, keyRules = {count = "w"}}
+end
+`
+    err := PoolFromCode("abi", code)
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer PoolDestroy("abi")
+    r, err := PoolCallFunc("abi", "run", &DataSessionType{OpParams: map[string]string{"n": "7"}})
+    if err != nil {
+        t.Fatal(err)
+    }
+    if r.StateTree["count"] != "7" || r.KeyRules["count"] != "w" {
+        t.Errorf("%v", r)
+    }
+    lRuntime.Lock()
+    pool := lRuntime.poolMap["abi"]
+    lRuntime.Unlock()
+    cycles := func() (int) {
+        pool.Lock()
+        defer pool.Unlock()
+        n := 0
+        for _, c := range pool.cycle {
+            n += c
+        }
+        return n
+    }
+    before := cycles()
+    _, err = PoolCallFunc("abi", "run", &DataSessionType{OpParams: map[string]string{"n": "x"}})
+    if err == nil || !strings.Contains(err.Error(), "invalid opParams:n") {
+        t.Errorf("expected invalid opParams, got %v", err)
+    }
+    if after := cycles(); after != before {
+        t.Errorf("rejected params used a state, cycles %d -> %d", before, after)
+    }
+}
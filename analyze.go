@@ -0,0 +1,142 @@
+////////////////////////////////
+package lyncs
+
+import (
+    "fmt"
+    "slices"
+    "sort"
+    "strconv"
+    "strings"
+)
+
+////////////////////////////////
+type DiagnosticType struct {
+    Line int
+    Level string
+    Code string
+    Message string
+}
+
+////////////////////////////////
+const analyzeMaxRep = 1 << 16
+
+////////////////////////////////
+func CodeAnalyze(code string) ([]DiagnosticType, error) {
+    bc, err := stateDumpCode(code, 0)
+    if err != nil {
+        return nil, err
+    }
+    dump, err := bcParse(bc)
+    if err != nil {
+        return nil, err
+    }
+    fr2 := 0
+    if dump.flags & bcDumpFlagFR2 != 0 {
+        fr2 = 1
+    }
+    result := []DiagnosticType{}
+    for _, pt := range dump.protos {
+        result = analyzeProto(pt, fr2, result)
+    }
+    sort.SliceStable(result, func(i, j int) (bool) {
+        return result[i].Line < result[j].Line
+    })
+    return result, nil
+}
+
+////////////////////////////////
+func analyzeProto(pt *bcProtoType, fr2 int, result []DiagnosticType) ([]DiagnosticType) {
+    lines := bcLineInfo(pt)
+    add := func(pc int, level string, code string, msg string) {
+        line := 0
+        if pc < len(lines) {
+            line = lines[pc]
+        }
+        result = append(result, DiagnosticType{Line: line, Level: level, Code: code, Message: msg})
+    }
+    target := make(map[int]bool)
+    for pc, ins := range pt.ins {
+        if int(ins & 0xff) < len(bcOpList) && bcOpList[ins & 0xff].cd == bcModeJump {
+            _, _, _, d := bcInsDecode(ins)
+            target[pc + 1 + d - bcBiasJ] = true
+        }
+    }
+    reg := make(map[int]string)
+    for pc, ins := range pt.ins {
+        if target[pc] {
+            clear(reg)
+        }
+        a, b, c, d := bcInsDecode(ins)
+        op := bcInsOp(pt, pc)
+        switch op {
+        case "GGET":
+            name := analyzeStr(pt, d)
+            if slices.Contains(stateRemoveMap["_G"], name) {
+                add(pc, "error", "removed-global", fmt.Sprintf("global %s is removed", name))
+            }
+            if name == "pairs" || name == "next" {
+                add(pc, "info", "pairs-order", fmt.Sprintf("%s iteration order is not deterministic", name))
+            }
+            reg[a] = "g:" + name
+            continue
+        case "GSET":
+            name := analyzeStr(pt, d)
+            if name != "session" && name != "state" && name != "abi" && name != poolMigrateFunc && !slices.Contains(lRuntime.cfg.Callbacks, name) {
+                add(pc, "error", "global-write", fmt.Sprintf("write to global %s", name))
+            }
+        case "TGETS":
+            name := analyzeStr(pt, c)
+            lib := strings.TrimPrefix(reg[b], "g:")
+            if lib != reg[b] && slices.Contains(stateRemoveMap[lib], name) {
+                add(pc, "error", "removed-global", fmt.Sprintf("global %s.%s is removed", lib, name))
+            }
+            if name == "rep" && reg[b] == "g:string" {
+                reg[a] = "f:rep"
+                continue
+            }
+        case "KSHORT":
+            reg[a] = "k:" + strconv.Itoa(int(int16(d)))
+            continue
+        case "KNUM":
+            if d < len(pt.kn) {
+                reg[a] = "k:" + disasmKnum(pt.kn[d])
+                continue
+            }
+        case "CALL", "CALLM", "CALLT", "CALLMT":
+            if reg[a] == "f:rep" {
+                v, isConst := strings.CutPrefix(reg[a+2+fr2], "k:")
+                n, _ := strconv.ParseFloat(v, 64)
+                if !isConst || op == "CALLM" || op == "CALLMT" {
+                    add(pc, "warning", "string-rep", "string.rep with non-constant size")
+                } else if n > analyzeMaxRep {
+                    add(pc, "error", "string-rep", fmt.Sprintf("string.rep with huge size %s", v))
+                }
+            }
+        case "LOOP":
+            add(pc, "info", "unbounded-loop", "loop without gas budget")
+        }
+        if int(ins & 0xff) >= len(bcOpList) {
+            continue
+        }
+        switch bcOpList[ins & 0xff].a {
+        case bcModeDst:
+            delete(reg, a)
+        case bcModeBase:
+            for k := range reg {
+                if k >= a {
+                    delete(reg, k)
+                }
+            }
+        }
+    }
+    return result
+}
+
+////////////////////////////////
+func analyzeStr(pt *bcProtoType, i int) (string) {
+    i = len(pt.kgc) - 1 - i
+    if i < 0 || i >= len(pt.kgc) || pt.kgc[i].kind != bcKgcStr {
+        return ""
+    }
+    return pt.kgc[i].str
+}
@@ -0,0 +1,59 @@
+////////////////////////////////
+package lyncs
+
+import (
+    "testing"
+)
+
+////////////////////////////////
+func TestCodeAnalyze(t *testing.T) {
+    cases := []struct {
+        code string
+        level string
+        diag string
+    }{
+        {`local x = string.rep("a", 1e9)`, "error", "string-rep"},
+        {`local x = string.rep("a", 16)`, "", ""},
+        {`local x = string.rep("a", tonumber("9"))`, "warning", "string-rep"},
+        {`local t = {rep = function(s, n) return s end} local x = t.rep("a", 1e9)`, "", ""},
+        {`local x = session.rep("a", 1e9)`, "", ""},
+        {`for k, v in pairs({}) do end`, "info", "pairs-order"},
+        {`local k = next({})`, "info", "pairs-order"},
+        {`while true do end`, "info", "unbounded-loop"},
+        {`local x = loadstring`, "error", "removed-global"},
+        {`local x = math.random`, "error", "removed-global"},
+        {`foo = 1`, "error", "global-write"},
+        {`function run() end`, "", ""},
+    }
+    for _, c := range cases {
+        diags, err := CodeAnalyze(c.code)
+        if err != nil {
+            t.Fatal(err)
+        }
+        if c.diag == "" {
+            if len(diags) > 0 {
+                t.Errorf("%s: unexpected %+v", c.code, diags)
+            }
+            continue
+        }
+        if len(diags) != 1 || diags[0].Level != c.level || diags[0].Code != c.diag {
+            t.Errorf("%s: expected %s %s, got %+v", c.code, c.level, c.diag, diags)
+        }
+    }
+}
+
+////////////////////////////////
+func TestCodeVerifyDiag(t *testing.T) {
+    code := "function init() return {} end\nfunction run()\n  for k in pairs({}) do end\n  return {}\nend\n"
+    data, diags, err := CodeVerifyDiag(code)
+    if err != nil || len(data) == 0 {
+        t.Fatal(err)
+    }
+    if len(diags) != 1 || diags[0].Line != 3 || diags[0].Level != "info" {
+        t.Errorf("%+v", diags)
+    }
+    _, diags, err = CodeVerifyDiag(code + "local x = string.rep('a', 1e9)\n")
+    if err == nil || len(diags) != 2 {
+        t.Errorf("expected error, got %v %+v", err, diags)
+    }
+}
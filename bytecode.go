@@ -0,0 +1,911 @@
+////////////////////////////////
+package lyncs
+
+import (
+    "bytes"
+    "fmt"
+    "math"
+    "encoding/binary"
+)
+
+////////////////////////////////
+const (
+    bcDumpFlagBE = 0x01
+    bcDumpFlagStrip = 0x02
+    bcDumpFlagFFI = 0x04
+    bcDumpFlagFR2 = 0x08
+    bcDumpFlagDeterministic = 0x80000000
+    bcProtoChild = 0x01
+    bcProtoVararg = 0x02
+    bcProtoFFI = 0x04
+    bcKgcChild = 0
+    bcKgcTab = 1
+    bcKgcI64 = 2
+    bcKgcU64 = 3
+    bcKgcComplex = 4
+    bcKgcStr = 5
+    bcKtabNil = 0
+    bcKtabFalse = 1
+    bcKtabTrue = 2
+    bcKtabInt = 3
+    bcKtabNum = 4
+    bcKtabStr = 5
+    bcUvLocal = 0x8000
+    bcUvImmutable = 0x4000
+    bcBiasJ = 0x8000
+    bcMaxSlots = 250
+)
+
+////////////////////////////////
+const (
+    bcModeNone = iota
+    bcModeDst
+    bcModeBase
+    bcModeVar
+    bcModeRBase
+    bcModeUV
+    bcModeLit
+    bcModeLitS
+    bcModePri
+    bcModeNum
+    bcModeStr
+    bcModeTab
+    bcModeFunc
+    bcModeJump
+    bcModeCData
+)
+
+////////////////////////////////
+type bcOpType struct {
+    name string
+    a int
+    b int
+    cd int
+}
+
+////////////////////////////////
+var bcOpList = []bcOpType{
+    {"ISLT", bcModeVar, bcModeNone, bcModeVar},
+    {"ISGE", bcModeVar, bcModeNone, bcModeVar},
+    {"ISLE", bcModeVar, bcModeNone, bcModeVar},
+    {"ISGT", bcModeVar, bcModeNone, bcModeVar},
+    {"ISEQV", bcModeVar, bcModeNone, bcModeVar},
+    {"ISNEV", bcModeVar, bcModeNone, bcModeVar},
+    {"ISEQS", bcModeVar, bcModeNone, bcModeStr},
+    {"ISNES", bcModeVar, bcModeNone, bcModeStr},
+    {"ISEQN", bcModeVar, bcModeNone, bcModeNum},
+    {"ISNEN", bcModeVar, bcModeNone, bcModeNum},
+    {"ISEQP", bcModeVar, bcModeNone, bcModePri},
+    {"ISNEP", bcModeVar, bcModeNone, bcModePri},
+    {"ISTC", bcModeDst, bcModeNone, bcModeVar},
+    {"ISFC", bcModeDst, bcModeNone, bcModeVar},
+    {"IST", bcModeNone, bcModeNone, bcModeVar},
+    {"ISF", bcModeNone, bcModeNone, bcModeVar},
+    {"ISTYPE", bcModeVar, bcModeNone, bcModeLit},
+    {"ISNUM", bcModeVar, bcModeNone, bcModeLit},
+    {"MOV", bcModeDst, bcModeNone, bcModeVar},
+    {"NOT", bcModeDst, bcModeNone, bcModeVar},
+    {"UNM", bcModeDst, bcModeNone, bcModeVar},
+    {"LEN", bcModeDst, bcModeNone, bcModeVar},
+    {"ADDVN", bcModeDst, bcModeVar, bcModeNum},
+    {"SUBVN", bcModeDst, bcModeVar, bcModeNum},
+    {"MULVN", bcModeDst, bcModeVar, bcModeNum},
+    {"DIVVN", bcModeDst, bcModeVar, bcModeNum},
+    {"MODVN", bcModeDst, bcModeVar, bcModeNum},
+    {"ADDNV", bcModeDst, bcModeVar, bcModeNum},
+    {"SUBNV", bcModeDst, bcModeVar, bcModeNum},
+    {"MULNV", bcModeDst, bcModeVar, bcModeNum},
+    {"DIVNV", bcModeDst, bcModeVar, bcModeNum},
+    {"MODNV", bcModeDst, bcModeVar, bcModeNum},
+    {"ADDVV", bcModeDst, bcModeVar, bcModeVar},
+    {"SUBVV", bcModeDst, bcModeVar, bcModeVar},
+    {"MULVV", bcModeDst, bcModeVar, bcModeVar},
+    {"DIVVV", bcModeDst, bcModeVar, bcModeVar},
+    {"MODVV", bcModeDst, bcModeVar, bcModeVar},
+    {"POW", bcModeDst, bcModeVar, bcModeVar},
+    {"CAT", bcModeDst, bcModeRBase, bcModeRBase},
+    {"KSTR", bcModeDst, bcModeNone, bcModeStr},
+    {"KCDATA", bcModeDst, bcModeNone, bcModeCData},
+    {"KSHORT", bcModeDst, bcModeNone, bcModeLitS},
+    {"KNUM", bcModeDst, bcModeNone, bcModeNum},
+    {"KPRI", bcModeDst, bcModeNone, bcModePri},
+    {"KNIL", bcModeBase, bcModeNone, bcModeBase},
+    {"UGET", bcModeDst, bcModeNone, bcModeUV},
+    {"USETV", bcModeUV, bcModeNone, bcModeVar},
+    {"USETS", bcModeUV, bcModeNone, bcModeStr},
+    {"USETN", bcModeUV, bcModeNone, bcModeNum},
+    {"USETP", bcModeUV, bcModeNone, bcModePri},
+    {"UCLO", bcModeRBase, bcModeNone, bcModeJump},
+    {"FNEW", bcModeDst, bcModeNone, bcModeFunc},
+    {"TNEW", bcModeDst, bcModeNone, bcModeLit},
+    {"TDUP", bcModeDst, bcModeNone, bcModeTab},
+    {"GGET", bcModeDst, bcModeNone, bcModeStr},
+    {"GSET", bcModeVar, bcModeNone, bcModeStr},
+    {"TGETV", bcModeDst, bcModeVar, bcModeVar},
+    {"TGETS", bcModeDst, bcModeVar, bcModeStr},
+    {"TGETB", bcModeDst, bcModeVar, bcModeLit},
+    {"TGETR", bcModeDst, bcModeVar, bcModeVar},
+    {"TSETV", bcModeVar, bcModeVar, bcModeVar},
+    {"TSETS", bcModeVar, bcModeVar, bcModeStr},
+    {"TSETB", bcModeVar, bcModeVar, bcModeLit},
+    {"TSETM", bcModeBase, bcModeNone, bcModeNum},
+    {"TSETR", bcModeVar, bcModeVar, bcModeVar},
+    {"CALLM", bcModeBase, bcModeLit, bcModeLit},
+    {"CALL", bcModeBase, bcModeLit, bcModeLit},
+    {"CALLMT", bcModeBase, bcModeNone, bcModeLit},
+    {"CALLT", bcModeBase, bcModeNone, bcModeLit},
+    {"ITERC", bcModeBase, bcModeLit, bcModeLit},
+    {"ITERN", bcModeBase, bcModeLit, bcModeLit},
+    {"VARG", bcModeBase, bcModeLit, bcModeLit},
+    {"ISNEXT", bcModeBase, bcModeNone, bcModeJump},
+    {"RETM", bcModeBase, bcModeNone, bcModeLit},
+    {"RET", bcModeRBase, bcModeNone, bcModeLit},
+    {"RET0", bcModeRBase, bcModeNone, bcModeLit},
+    {"RET1", bcModeRBase, bcModeNone, bcModeLit},
+    {"FORI", bcModeBase, bcModeNone, bcModeJump},
+    {"JFORI", bcModeBase, bcModeNone, bcModeJump},
+    {"FORL", bcModeBase, bcModeNone, bcModeJump},
+    {"IFORL", bcModeBase, bcModeNone, bcModeJump},
+    {"JFORL", bcModeBase, bcModeNone, bcModeLit},
+    {"ITERL", bcModeBase, bcModeNone, bcModeJump},
+    {"IITERL", bcModeBase, bcModeNone, bcModeJump},
+    {"JITERL", bcModeBase, bcModeNone, bcModeLit},
+    {"LOOP", bcModeRBase, bcModeNone, bcModeJump},
+    {"ILOOP", bcModeRBase, bcModeNone, bcModeJump},
+    {"JLOOP", bcModeRBase, bcModeNone, bcModeLit},
+    {"JMP", bcModeRBase, bcModeNone, bcModeJump},
+    {"FUNCF", bcModeRBase, bcModeNone, bcModeNone},
+    {"IFUNCF", bcModeRBase, bcModeNone, bcModeNone},
+    {"JFUNCF", bcModeRBase, bcModeNone, bcModeLit},
+    {"FUNCV", bcModeRBase, bcModeNone, bcModeNone},
+    {"IFUNCV", bcModeRBase, bcModeNone, bcModeNone},
+    {"JFUNCV", bcModeRBase, bcModeNone, bcModeLit},
+    {"FUNCC", bcModeRBase, bcModeNone, bcModeNone},
+    {"FUNCCW", bcModeRBase, bcModeNone, bcModeNone},
+}
+
+////////////////////////////////
+const bcOpJMP = 88
+const bcOpITERC = 69
+
+////////////////////////////////
+var bcOpForbidden = map[string]bool{
+    "KCDATA": true,
+    "ISTYPE": true,
+    "ISNUM": true,
+    "TGETR": true,
+    "TSETR": true,
+    "JFORI": true,
+    "IFORL": true,
+    "JFORL": true,
+    "IITERL": true,
+    "JITERL": true,
+    "ILOOP": true,
+    "JLOOP": true,
+    "FUNCF": true,
+    "IFUNCF": true,
+    "JFUNCF": true,
+    "FUNCV": true,
+    "IFUNCV": true,
+    "JFUNCV": true,
+    "FUNCC": true,
+    "FUNCCW": true,
+}
+
+////////////////////////////////
+type bcKtabType struct {
+    array []any
+    hash [][2]any
+}
+
+////////////////////////////////
+type bcKgcType struct {
+    kind int
+    str string
+    tab *bcKtabType
+    child int
+}
+
+////////////////////////////////
+type bcKnumType struct {
+    isInt bool
+    i int32
+    n float64
+}
+
+////////////////////////////////
+type bcProtoType struct {
+    flags uint8
+    numParams uint8
+    frameSize uint8
+    uv []uint16
+    ins []uint32
+    kgc []bcKgcType
+    kn []bcKnumType
+    firstLine uint32
+    numLine uint32
+    debug []byte
+    pos int
+}
+
+////////////////////////////////
+type bcDumpType struct {
+    flags uint32
+    name string
+    protos []*bcProtoType
+}
+
+////////////////////////////////
+type bcReaderType struct {
+    data []byte
+    pos int
+    err error
+}
+
+////////////////////////////////
+func bcReadByte(r *bcReaderType) (uint8) {
+    if r.err != nil {
+        return 0
+    }
+    if r.pos >= len(r.data) {
+        r.err = fmt.Errorf("truncated bytecode @bcReadByte")
+        return 0
+    }
+    r.pos ++
+    return r.data[r.pos-1]
+}
+
+////////////////////////////////
+func bcReadBytes(r *bcReaderType, n uint32) ([]byte) {
+    if r.err != nil {
+        return nil
+    }
+    if uint64(n) > uint64(len(r.data) - r.pos) {
+        r.err = fmt.Errorf("truncated bytecode @bcReadBytes")
+        return nil
+    }
+    r.pos += int(n)
+    return r.data[r.pos-int(n):r.pos]
+}
+
+////////////////////////////////
+func bcReadUleb(r *bcReaderType) (uint32) {
+    var v uint32
+    for sh := uint(0); r.err == nil; sh += 7 {
+        b := bcReadByte(r)
+        if sh == 28 && b > 0x0f {
+            r.err = fmt.Errorf("invalid uleb128 @bcReadUleb")
+            return 0
+        }
+        v |= uint32(b & 0x7f) << sh
+        if b < 0x80 {
+            return v
+        }
+    }
+    return 0
+}
+
+////////////////////////////////
+func bcReadUleb33(r *bcReaderType) (uint32, bool) {
+    b := bcReadByte(r)
+    isNum := b & 1 != 0
+    v := uint32(b >> 1)
+    if v < 0x40 {
+        return v, isNum
+    }
+    v &= 0x3f
+    for sh := uint(6); r.err == nil; sh += 7 {
+        b = bcReadByte(r)
+        if sh == 27 && b > 0x1f {
+            r.err = fmt.Errorf("invalid uleb128 @bcReadUleb33")
+            return 0, false
+        }
+        v |= uint32(b & 0x7f) << sh
+        if b < 0x80 {
+            break
+        }
+    }
+    return v, isNum
+}
+
+////////////////////////////////
+func bcReadKtabk(r *bcReaderType) (any) {
+    tp := bcReadUleb(r)
+    switch {
+    case tp >= bcKtabStr:
+        return string(bcReadBytes(r, tp-bcKtabStr))
+    case tp == bcKtabInt:
+        return int32(bcReadUleb(r))
+    case tp == bcKtabNum:
+        lo := bcReadUleb(r)
+        hi := bcReadUleb(r)
+        return math.Float64frombits(uint64(hi) << 32 | uint64(lo))
+    case tp == bcKtabTrue:
+        return true
+    case tp == bcKtabFalse:
+        return false
+    }
+    return nil
+}
+
+////////////////////////////////
+func bcReadKtab(r *bcReaderType) (*bcKtabType) {
+    narray := bcReadUleb(r)
+    nhash := bcReadUleb(r)
+    if uint64(narray) + uint64(nhash) * 2 > uint64(len(r.data) - r.pos) {
+        r.err = fmt.Errorf("truncated bytecode @bcReadKtab")
+        return nil
+    }
+    tab := &bcKtabType{
+        array: make([]any, 0, narray),
+        hash: make([][2]any, 0, nhash),
+    }
+    for i := uint32(0); i < narray && r.err == nil; i ++ {
+        tab.array = append(tab.array, bcReadKtabk(r))
+    }
+    for i := uint32(0); i < nhash && r.err == nil; i ++ {
+        k := bcReadKtabk(r)
+        v := bcReadKtabk(r)
+        tab.hash = append(tab.hash, [2]any{k, v})
+    }
+    return tab
+}
+
+////////////////////////////////
+func bcReadProto(r *bcReaderType, flags uint32) (*bcProtoType) {
+    pt := &bcProtoType{}
+    pt.flags = bcReadByte(r)
+    pt.numParams = bcReadByte(r)
+    pt.frameSize = bcReadByte(r)
+    numUV := bcReadByte(r)
+    numKgc := bcReadUleb(r)
+    numKn := bcReadUleb(r)
+    numBC := bcReadUleb(r)
+    sizeDebug := uint32(0)
+    if flags & bcDumpFlagStrip == 0 {
+        sizeDebug = bcReadUleb(r)
+        if sizeDebug > 0 {
+            pt.firstLine = bcReadUleb(r)
+            pt.numLine = bcReadUleb(r)
+        }
+    }
+    if r.err != nil {
+        return nil
+    }
+    if uint64(numBC) * 4 + uint64(numUV) * 2 + uint64(numKgc) + uint64(numKn) > uint64(len(r.data) - r.pos) {
+        r.err = fmt.Errorf("truncated bytecode @bcReadProto")
+        return nil
+    }
+    pt.pos = r.pos
+    pt.ins = make([]uint32, numBC)
+    for i := range pt.ins {
+        pt.ins[i] = binary.LittleEndian.Uint32(bcReadBytes(r, 4))
+    }
+    pt.uv = make([]uint16, numUV)
+    for i := range pt.uv {
+        pt.uv[i] = binary.LittleEndian.Uint16(bcReadBytes(r, 2))
+    }
+    pt.kgc = make([]bcKgcType, numKgc)
+    for i := range pt.kgc {
+        tp := bcReadUleb(r)
+        pt.kgc[i].kind = int(min(tp, bcKgcStr))
+        switch {
+        case tp >= bcKgcStr:
+            pt.kgc[i].str = string(bcReadBytes(r, tp-bcKgcStr))
+        case tp == bcKgcTab:
+            pt.kgc[i].tab = bcReadKtab(r)
+        case tp == bcKgcChild:
+            pt.kgc[i].child = -1
+        default:
+            r.err = fmt.Errorf("cdata constant @bcReadProto")
+        }
+        if r.err != nil {
+            return nil
+        }
+    }
+    pt.kn = make([]bcKnumType, numKn)
+    for i := range pt.kn {
+        lo, isNum := bcReadUleb33(r)
+        if isNum {
+            hi := bcReadUleb(r)
+            pt.kn[i].n = math.Float64frombits(uint64(hi) << 32 | uint64(lo))
+        } else {
+            pt.kn[i].isInt = true
+            pt.kn[i].i = int32(lo)
+        }
+    }
+    pt.debug = bcReadBytes(r, sizeDebug)
+    if r.err != nil {
+        return nil
+    }
+    return pt
+}
+
+////////////////////////////////
+func bcParse(bc []byte) (*bcDumpType, error) {
+    r := &bcReaderType{data: bc}
+    head := bcReadBytes(r, 4)
+    if r.err != nil || string(head[:3]) != "\x1bLJ" {
+        return nil, fmt.Errorf("invalid header @bcParse")
+    }
+    if head[3] != 2 {
+        return nil, fmt.Errorf("unsupported version @bcParse")
+    }
+    dump := &bcDumpType{}
+    dump.flags = bcReadUleb(r)
+    if dump.flags & bcDumpFlagStrip == 0 {
+        dump.name = string(bcReadBytes(r, bcReadUleb(r)))
+    }
+    stack := []int{}
+    for r.err == nil {
+        lenProto := bcReadUleb(r)
+        if r.err != nil || lenProto == 0 {
+            break
+        }
+        start := r.pos
+        pt := bcReadProto(r, dump.flags)
+        if r.err != nil {
+            break
+        }
+        if uint32(r.pos - start) != lenProto {
+            return nil, fmt.Errorf("proto size mismatch @bcParse")
+        }
+        for i := range pt.kgc {
+            if pt.kgc[i].kind != bcKgcChild {
+                continue
+            }
+            if len(stack) == 0 {
+                return nil, fmt.Errorf("child underflow @bcParse")
+            }
+            pt.kgc[i].child = stack[len(stack)-1]
+            stack = stack[:len(stack)-1]
+        }
+        stack = append(stack, len(dump.protos))
+        dump.protos = append(dump.protos, pt)
+    }
+    if r.err != nil {
+        return nil, r.err
+    }
+    if r.pos != len(bc) {
+        return nil, fmt.Errorf("trailing data @bcParse")
+    }
+    if len(stack) != 1 || stack[0] != len(dump.protos) - 1 {
+        return nil, fmt.Errorf("dangling proto @bcParse")
+    }
+    return dump, nil
+}
+
+////////////////////////////////
+type bcSlotSetType [4]uint64
+
+////////////////////////////////
+func bcSlotHas(set *bcSlotSetType, i int) (bool) {
+    return i >= 0 && i < 256 && set[i>>6] & (1 << (i & 63)) != 0
+}
+
+////////////////////////////////
+func bcSlotSet(set *bcSlotSetType, i int, v bool) {
+    if i < 0 || i >= 256 {
+        return
+    }
+    if v {
+        set[i>>6] |= 1 << (i & 63)
+    } else {
+        set[i>>6] &^= 1 << (i & 63)
+    }
+}
+
+////////////////////////////////
+func bcSlotClear(set *bcSlotSetType, from int, to int) {
+    for i := max(from, 0); i <= to && i < 256; i ++ {
+        bcSlotSet(set, i, false)
+    }
+}
+
+////////////////////////////////
+func bcVerify(bc []byte) ([]byte, error) {
+    dump, err := bcParse(bc)
+    if err != nil {
+        return nil, err
+    }
+    if dump.flags &^ (bcDumpFlagStrip | bcDumpFlagFR2) != 0 {
+        return nil, fmt.Errorf("unsupported flags @bcVerify")
+    }
+    _, err = stateSandboxHash()
+    if err != nil {
+        return nil, err
+    }
+    if uint32(bcSandbox[4]) & bcDumpFlagFR2 != dump.flags & bcDumpFlagFR2 {
+        return nil, fmt.Errorf("frame layout mismatch @bcVerify")
+    }
+    fr2 := 0
+    if dump.flags & bcDumpFlagFR2 != 0 {
+        fr2 = 1
+    }
+    parent := make([]int, len(dump.protos))
+    for i := range parent {
+        parent[i] = -1
+    }
+    for i, pt := range dump.protos {
+        for _, k := range pt.kgc {
+            if k.kind != bcKgcChild {
+                continue
+            }
+            if parent[k.child] >= 0 {
+                return nil, fmt.Errorf("shared proto @bcVerify")
+            }
+            parent[k.child] = i
+        }
+    }
+    result := append([]byte{}, bc...)
+    for i, pt := range dump.protos {
+        var ptParent *bcProtoType
+        if parent[i] >= 0 {
+            ptParent = dump.protos[parent[i]]
+        }
+        err = bcVerifyProto(pt, ptParent, fr2)
+        if err == nil {
+            err = bcVerifyFlow(dump, pt, fr2)
+        }
+        if err != nil {
+            return nil, fmt.Errorf("%s:%d", err.Error(), i)
+        }
+        for pc, ins := range pt.ins {
+            switch bcOpList[ins & 0xff].name {
+            case "ISNEXT":
+                result[pt.pos + pc*4] = bcOpJMP
+            case "ITERN":
+                result[pt.pos + pc*4] = bcOpITERC
+            }
+        }
+    }
+    return result, nil
+}
+
+////////////////////////////////
+func bcVerifyProto(pt *bcProtoType, ptParent *bcProtoType, fr2 int) (error) {
+    if pt.flags &^ (bcProtoChild | bcProtoVararg) != 0 {
+        return fmt.Errorf("unsupported proto flags @bcVerifyProto")
+    }
+    hasChild := false
+    for _, k := range pt.kgc {
+        if k.kind == bcKgcChild {
+            hasChild = true
+        }
+        if k.kind != bcKgcTab {
+            continue
+        }
+        for _, kv := range k.tab.hash {
+            if kv[0] == nil {
+                return fmt.Errorf("nil table key @bcVerifyProto")
+            }
+            if n, ok := kv[0].(float64); ok && math.IsNaN(n) {
+                return fmt.Errorf("nan table key @bcVerifyProto")
+            }
+        }
+    }
+    if hasChild != (pt.flags & bcProtoChild != 0) {
+        return fmt.Errorf("child flag mismatch @bcVerifyProto")
+    }
+    frameSize := int(pt.frameSize)
+    if frameSize > bcMaxSlots || int(pt.numParams) > frameSize {
+        return fmt.Errorf("invalid frame size @bcVerifyProto")
+    }
+    if ptParent == nil && len(pt.uv) > 0 {
+        return fmt.Errorf("upvalue in main chunk @bcVerifyProto")
+    }
+    for _, uv := range pt.uv {
+        if uv & bcUvLocal != 0 {
+            if uv & 0x3f00 != 0 || int(uv & 0xff) >= int(ptParent.frameSize) {
+                return fmt.Errorf("invalid upvalue @bcVerifyProto")
+            }
+        } else if int(uv) >= len(ptParent.uv) {
+            return fmt.Errorf("invalid upvalue @bcVerifyProto")
+        }
+    }
+    lenIns := len(pt.ins)
+    if lenIns == 0 {
+        return fmt.Errorf("empty bytecode @bcVerifyProto")
+    }
+    for pc, ins := range pt.ins {
+        if int(ins & 0xff) >= len(bcOpList) {
+            return fmt.Errorf("invalid opcode at %d @bcVerifyProto", pc)
+        }
+        op := bcOpList[ins & 0xff]
+        if bcOpForbidden[op.name] {
+            return fmt.Errorf("forbidden opcode %s at %d @bcVerifyProto", op.name, pc)
+        }
+        a, b, c, d := bcInsDecode(ins)
+        if op.b == bcModeNone {
+            c = d
+        }
+        valid := bcVerifyOperand(pt, op.a, a, pc) && bcVerifyOperand(pt, op.b, b, pc) && bcVerifyOperand(pt, op.cd, c, pc)
+        switch op.name {
+        case "ISLT", "ISGE", "ISLE", "ISGT", "ISEQV", "ISNEV", "ISEQS", "ISNES", "ISEQN", "ISNEN", "ISEQP", "ISNEP", "ISTC", "ISFC", "IST", "ISF":
+            valid = valid && bcInsOp(pt, pc+1) == "JMP"
+        case "KNIL":
+            valid = valid && a <= d
+        case "CAT":
+            valid = valid && b < c
+        case "CALL", "CALLT":
+            valid = valid && c >= 1 && a + fr2 + c - 1 < frameSize && (op.name == "CALLT" || b < 2 || a + b - 2 < frameSize)
+        case "CALLM", "CALLMT":
+            valid = valid && a + fr2 + c < frameSize && (op.name == "CALLMT" || b < 2 || a + b - 2 < frameSize)
+        case "ITERC", "ITERN":
+            valid = valid && a >= 3 && a + 3 + fr2 <= frameSize && (b < 2 || a + b - 2 < frameSize) && bcInsOp(pt, pc+1) == "ITERL"
+        case "ISNEXT":
+            valid = valid && a >= 3 && bcInsOp(pt, pc + 1 + d - bcBiasJ) == "ITERN"
+        case "ITERL":
+            valid = valid && a >= 1
+        case "VARG":
+            valid = valid && pt.flags & bcProtoVararg != 0 && c == int(pt.numParams) && (b < 2 || a + b - 2 < frameSize)
+        case "TSETM":
+            valid = valid && a >= 1
+        case "FORI", "FORL":
+            valid = valid && a + 3 < frameSize
+        case "RET":
+            valid = valid && d >= 1 && a + d - 1 <= frameSize
+        case "RETM":
+            valid = valid && a + d <= frameSize
+        case "RET0":
+            valid = valid && d == 1
+        case "RET1":
+            valid = valid && d == 2 && a < frameSize
+        }
+        if !valid {
+            return fmt.Errorf("invalid operand %s at %d @bcVerifyProto", op.name, pc)
+        }
+    }
+    switch bcInsOp(pt, lenIns-1) {
+    case "RET", "RETM", "RET0", "RET1", "CALLT", "CALLMT", "JMP", "UCLO":
+    default:
+        return fmt.Errorf("missing return @bcVerifyProto")
+    }
+    return nil
+}
+
+////////////////////////////////
+func bcVerifyOperand(pt *bcProtoType, mode int, v int, pc int) (bool) {
+    switch mode {
+    case bcModeDst, bcModeVar, bcModeBase:
+        return v < int(pt.frameSize)
+    case bcModeRBase:
+        return v <= int(pt.frameSize)
+    case bcModeUV:
+        return v < len(pt.uv)
+    case bcModePri:
+        return v <= 2
+    case bcModeNum:
+        return v < len(pt.kn)
+    case bcModeStr, bcModeTab, bcModeFunc:
+        if v >= len(pt.kgc) {
+            return false
+        }
+        kind := pt.kgc[len(pt.kgc)-1-v].kind
+        return mode == bcModeStr && kind == bcKgcStr || mode == bcModeTab && kind == bcKgcTab || mode == bcModeFunc && kind == bcKgcChild
+    case bcModeJump:
+        target := pc + 1 + v - bcBiasJ
+        return target >= 0 && target < len(pt.ins)
+    case bcModeCData:
+        return false
+    }
+    return true
+}
+
+////////////////////////////////
+func bcVerifyFlow(dump *bcDumpType, pt *bcProtoType, fr2 int) (error) {
+    lenIns := len(pt.ins)
+    target := make([]bool, lenIns)
+    for pc, ins := range pt.ins {
+        if bcOpList[ins & 0xff].cd == bcModeJump {
+            _, _, _, d := bcInsDecode(ins)
+            target[pc + 1 + d - bcBiasJ] = true
+        }
+    }
+    for pc, ins := range pt.ins {
+        a, _, c, d := bcInsDecode(ins)
+        base := -1
+        switch bcOpList[ins & 0xff].name {
+        case "CALLM":
+            base = a + 1 + fr2 + c
+        case "CALLMT":
+            base = a + 1 + fr2 + d
+        case "RETM":
+            base = a + d
+        case "TSETM":
+            base = a
+        default:
+            continue
+        }
+        if pc == 0 || target[pc] {
+            return fmt.Errorf("invalid multres at %d @bcVerifyFlow", pc)
+        }
+        aPrev, bPrev, _, _ := bcInsDecode(pt.ins[pc-1])
+        switch bcInsOp(pt, pc-1) {
+        case "CALL", "CALLM", "VARG":
+            if bPrev == 0 && aPrev == base {
+                continue
+            }
+        }
+        return fmt.Errorf("invalid multres at %d @bcVerifyFlow", pc)
+    }
+    state := make([][2]bcSlotSetType, lenIns)
+    reached := make([]bool, lenIns)
+    reached[0] = true
+    work := []int{0}
+    for len(work) > 0 {
+        pc := work[len(work)-1]
+        work = work[:len(work)-1]
+        s := state[pc][0]
+        open := state[pc][1]
+        ins := pt.ins[pc]
+        op := bcOpList[ins & 0xff]
+        a, _, _, d := bcInsDecode(ins)
+        next := []int{pc + 1}
+        switch op.name {
+        case "TNEW", "TDUP":
+            bcSlotSet(&s, a, true)
+        case "FNEW":
+            bcSlotSet(&s, a, false)
+            for _, uv := range dump.protos[pt.kgc[len(pt.kgc)-1-d].child].uv {
+                if uv & bcUvLocal != 0 {
+                    bcSlotSet(&open, int(uv & 0xff), true)
+                }
+            }
+        case "KNIL":
+            bcSlotClear(&s, a, d)
+        case "CALL", "CALLM", "ITERC", "ITERN":
+            bcSlotClear(&s, a, 255)
+            for i := range s {
+                s[i] &^= open[i]
+            }
+        case "VARG":
+            bcSlotClear(&s, a, 255)
+        case "FORI", "FORL", "ITERL":
+            bcSlotClear(&s, a - 1, a + 3)
+            next = append(next, pc + 1 + d - bcBiasJ)
+        case "UCLO":
+            bcSlotClear(&open, a, 255)
+            next = []int{pc + 1 + d - bcBiasJ}
+        case "JMP", "ISNEXT":
+            next = []int{pc + 1 + d - bcBiasJ}
+        case "RET", "RETM", "RET0", "RET1", "CALLT", "CALLMT":
+            next = nil
+        case "ISLT", "ISGE", "ISLE", "ISGT", "ISEQV", "ISNEV", "ISEQS", "ISNES", "ISEQN", "ISNEN", "ISEQP", "ISNEP", "IST", "ISF":
+            next = append(next, pc + 2)
+        case "ISTC", "ISFC":
+            bcSlotSet(&s, a, false)
+            next = append(next, pc + 2)
+        default:
+            if op.a == bcModeDst {
+                bcSlotSet(&s, a, false)
+            }
+        }
+        for _, n := range next {
+            if n < 0 || n >= lenIns {
+                return fmt.Errorf("fall through at %d @bcVerifyFlow", pc)
+            }
+            if !reached[n] {
+                reached[n] = true
+                state[n] = [2]bcSlotSetType{s, open}
+                work = append(work, n)
+                continue
+            }
+            merged := state[n]
+            for i := range s {
+                merged[0][i] &= s[i]
+                merged[1][i] |= open[i]
+            }
+            if merged != state[n] {
+                state[n] = merged
+                work = append(work, n)
+            }
+        }
+    }
+    for pc, ins := range pt.ins {
+        a, _, _, _ := bcInsDecode(ins)
+        if reached[pc] && bcOpList[ins & 0xff].name == "TSETM" && !bcSlotHas(&state[pc][0], a - 1) {
+            return fmt.Errorf("invalid table at %d @bcVerifyFlow", pc)
+        }
+    }
+    return nil
+}
+
+////////////////////////////////
+func bcInsDecode(ins uint32) (int, int, int, int) {
+    return int(ins >> 8 & 0xff), int(ins >> 24), int(ins >> 16 & 0xff), int(ins >> 16)
+}
+
+////////////////////////////////
+func bcInsOp(pt *bcProtoType, pc int) (string) {
+    if pc < 0 || pc >= len(pt.ins) || int(pt.ins[pc] & 0xff) >= len(bcOpList) {
+        return ""
+    }
+    return bcOpList[pt.ins[pc] & 0xff].name
+}
+
+////////////////////////////////
+func bcLineInfo(pt *bcProtoType) ([]int) {
+    size := 1
+    if pt.numLine >= 65536 {
+        size = 4
+    } else if pt.numLine >= 256 {
+        size = 2
+    }
+    if len(pt.debug) < len(pt.ins) * size {
+        return nil
+    }
+    lines := make([]int, len(pt.ins))
+    for i := range lines {
+        switch size {
+        case 1:
+            lines[i] = int(pt.debug[i])
+        case 2:
+            lines[i] = int(binary.LittleEndian.Uint16(pt.debug[i*2:]))
+        default:
+            lines[i] = int(binary.LittleEndian.Uint32(pt.debug[i*4:]))
+        }
+        lines[i] += int(pt.firstLine)
+    }
+    return lines
+}
+
+////////////////////////////////
+var bcVarNameList = []string{"", "(for index)", "(for limit)", "(for step)", "(for generator)", "(for state)", "(for control)"}
+
+////////////////////////////////
+type bcVarType struct {
+    name string
+    startPC int
+    endPC int
+}
+
+////////////////////////////////
+func bcDebugNames(pt *bcProtoType) ([]string, []bcVarType) {
+    size := 1
+    if pt.numLine >= 65536 {
+        size = 4
+    } else if pt.numLine >= 256 {
+        size = 2
+    }
+    if len(pt.debug) < len(pt.ins) * size {
+        return nil, nil
+    }
+    r := &bcReaderType{data: pt.debug, pos: len(pt.ins) * size}
+    readName := func() (string) {
+        n := bytes.IndexByte(r.data[r.pos:], 0)
+        if n < 0 {
+            r.err = fmt.Errorf("truncated name @bcDebugNames")
+            return ""
+        }
+        name := string(r.data[r.pos:r.pos+n])
+        r.pos += n + 1
+        return name
+    }
+    uvNames := make([]string, 0, len(pt.uv))
+    for range pt.uv {
+        uvNames = append(uvNames, readName())
+    }
+    vars := []bcVarType{}
+    lastPC := 0
+    for r.err == nil && r.pos < len(r.data) {
+        name := ""
+        vn := int(r.data[r.pos])
+        if vn == 0 {
+            break
+        } else if vn < len(bcVarNameList) {
+            name = bcVarNameList[vn]
+            r.pos ++
+        } else {
+            name = readName()
+        }
+        startPC := lastPC + int(bcReadUleb(r))
+        endPC := startPC + int(bcReadUleb(r))
+        lastPC = startPC
+        vars = append(vars, bcVarType{name: name, startPC: max(startPC-1, 0), endPC: max(endPC-1, 0)})
+    }
+    if r.err != nil {
+        return uvNames, nil
+    }
+    return uvNames, vars
+}
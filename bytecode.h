@@ -16,10 +16,10 @@ static int luaL_bcWriter(lua_State *s, const void *bc, size_t n, void *output) {
 }
 
 ////////////////////////////////
-static size_t luaL_bcDump(lua_State *s, bcBuffer *output) {
+static size_t luaL_bcDump(lua_State *s, bcBuffer *output, uint32_t flags) {
 	output->bc = NULL;
 	output->n = 0;
-	if (lua_dump(s,luaL_bcWriter,output,0x02)!=0) {
+	if (lua_dump(s,luaL_bcWriter,output,flags)!=0) {
 		free(output->bc);
 		output->bc = NULL;
 		output->n = 0;
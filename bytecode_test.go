@@ -0,0 +1,155 @@
+////////////////////////////////
+package lyncs
+
+import (
+    "encoding/binary"
+    "os"
+    "strings"
+    "testing"
+)
+
+////////////////////////////////
+func testBC(t *testing.T) ([]byte) {
+    t.Helper()
+    code, err := os.ReadFile("testdata/contract.lua")
+    if err != nil {
+        t.Fatal(err)
+    }
+    bc, err := stateDumpCode(string(code), bcDumpFlagStrip | bcDumpFlagDeterministic)
+    if err != nil {
+        t.Fatal(err)
+    }
+    return bc
+}
+
+////////////////////////////////
+func testFindIns(t *testing.T, dump *bcDumpType, name string) (*bcProtoType, int) {
+    t.Helper()
+    for _, pt := range dump.protos {
+        for pc := range pt.ins {
+            if bcInsOp(pt, pc) == name {
+                return pt, pc
+            }
+        }
+    }
+    t.Fatalf("missing %s", name)
+    return nil, 0
+}
+
+////////////////////////////////
+func testPatchD(t *testing.T, dump *bcDumpType, bc []byte, name string, d uint16) ([]byte) {
+    pt, pc := testFindIns(t, dump, name)
+    binary.LittleEndian.PutUint16(bc[pt.pos+pc*4+2:], d)
+    return bc
+}
+
+////////////////////////////////
+func TestBCVerify(t *testing.T) {
+    bc := testBC(t)
+    _, err := bcVerify(bc)
+    if err != nil {
+        t.Fatal(err)
+    }
+    cases := []struct {
+        name string
+        mutate func(*bcDumpType, []byte) ([]byte)
+        err string
+    }{
+        {"magic", func(dump *bcDumpType, bc []byte) ([]byte) {
+            bc[1] = 'X'
+            return bc
+        }, "invalid header"},
+        {"version", func(dump *bcDumpType, bc []byte) ([]byte) {
+            bc[3] = 3
+            return bc
+        }, "unsupported version"},
+        {"ffi flag", func(dump *bcDumpType, bc []byte) ([]byte) {
+            bc[4] |= bcDumpFlagFFI
+            return bc
+        }, "unsupported flags"},
+        {"big endian flag", func(dump *bcDumpType, bc []byte) ([]byte) {
+            bc[4] |= bcDumpFlagBE
+            return bc
+        }, "unsupported flags"},
+        {"frame layout", func(dump *bcDumpType, bc []byte) ([]byte) {
+            bc[4] ^= bcDumpFlagFR2
+            return bc
+        }, "frame layout mismatch"},
+        {"trailing data", func(dump *bcDumpType, bc []byte) ([]byte) {
+            return append(bc, 0)
+        }, "trailing data"},
+        {"proto flags", func(dump *bcDumpType, bc []byte) ([]byte) {
+            bc[dump.protos[0].pos-7] |= bcProtoFFI
+            return bc
+        }, "unsupported proto flags"},
+        {"constant index", func(dump *bcDumpType, bc []byte) ([]byte) {
+            return testPatchD(t, dump, bc, "GGET", 200)
+        }, "invalid operand GGET"},
+        {"table constant kind", func(dump *bcDumpType, bc []byte) ([]byte) {
+            return testPatchD(t, dump, bc, "TDUP", 0)
+        }, "invalid operand TDUP"},
+        {"number constant index", func(dump *bcDumpType, bc []byte) ([]byte) {
+            pt, pc := testFindIns(t, dump, "ADDVV")
+            bc[pt.pos+pc*4] = testOpIndex("ADDVN")
+            bc[pt.pos+pc*4+2] = 100
+            return bc
+        }, "invalid operand ADDVN"},
+        {"upvalue index", func(dump *bcDumpType, bc []byte) ([]byte) {
+            return testPatchD(t, dump, bc, "UGET", 5)
+        }, "invalid operand UGET"},
+        {"upvalue slot", func(dump *bcDumpType, bc []byte) ([]byte) {
+            pt, _ := testFindIns(t, dump, "UGET")
+            binary.LittleEndian.PutUint16(bc[pt.pos+len(pt.ins)*4:], bcUvLocal | bcUvImmutable | 100)
+            return bc
+        }, "invalid upvalue"},
+        {"jump past end", func(dump *bcDumpType, bc []byte) ([]byte) {
+            return testPatchD(t, dump, bc, "FORI", bcBiasJ + 100)
+        }, "invalid operand FORI"},
+        {"jump before start", func(dump *bcDumpType, bc []byte) ([]byte) {
+            return testPatchD(t, dump, bc, "FORL", 0)
+        }, "invalid operand FORL"},
+        {"opcode", func(dump *bcDumpType, bc []byte) ([]byte) {
+            pt, pc := testFindIns(t, dump, "MOV")
+            bc[pt.pos+pc*4] = 0xff
+            return bc
+        }, "invalid opcode"},
+        {"missing return", func(dump *bcDumpType, bc []byte) ([]byte) {
+            pt := dump.protos[0]
+            bc[pt.pos+(len(pt.ins)-1)*4] = testOpIndex("KSHORT")
+            return bc
+        }, "missing return"},
+    }
+    for _, c := range cases {
+        data := append([]byte{}, bc...)
+        dump, err := bcParse(data)
+        if err != nil {
+            t.Fatal(err)
+        }
+        data = c.mutate(dump, data)
+        _, err = bcVerify(data)
+        if err == nil || !strings.Contains(err.Error(), c.err) {
+            t.Errorf("%s: expected %q, got %v", c.name, c.err, err)
+        }
+    }
+}
+
+////////////////////////////////
+func TestBCVerifyTruncated(t *testing.T) {
+    bc := testBC(t)
+    for n := 0; n < len(bc); n ++ {
+        _, err := bcVerify(bc[:n])
+        if err == nil {
+            t.Fatalf("truncated at %d accepted", n)
+        }
+    }
+}
+
+////////////////////////////////
+func testOpIndex(name string) (byte) {
+    for i, op := range bcOpList {
+        if op.name == name {
+            return byte(i)
+        }
+    }
+    return 0xff
+}
@@ -0,0 +1,125 @@
+////////////////////////////////
+package lyncs
+
+import (
+    "bytes"
+    "crypto/sha256"
+    "encoding/hex"
+    "fmt"
+    "os"
+    "path/filepath"
+    "strings"
+    "time"
+)
+
+////////////////////////////////
+const cacheExt = ".lybc"
+
+////////////////////////////////
+func cacheFile(dir string, code string) (string, error) {
+    hashSandbox, err := stateSandboxHash()
+    if err != nil {
+        return "", err
+    }
+    hashSource := sha256.Sum256([]byte(code))
+    return filepath.Join(dir, hex.EncodeToString(hashSource[:]) + "-" + hex.EncodeToString(hashSandbox) + cacheExt), nil
+}
+
+////////////////////////////////
+func cacheLoad(dir string, code string) (*poolType) {
+    file, err := cacheFile(dir, code)
+    if err != nil {
+        return nil
+    }
+    data, err := os.ReadFile(file)
+    if err != nil {
+        return nil
+    }
+    c, err := BCDecode(data)
+    if err != nil {
+        return nil
+    }
+    hashSource := sha256.Sum256([]byte(code))
+    if !bytes.Equal(c.SourceHash, hashSource[:]) {
+        return nil
+    }
+    pool, err := poolFromBC(data)
+    if err != nil {
+        return nil
+    }
+    now := time.Now()
+    os.Chtimes(file, now, now)
+    pool.code = code
+    return pool
+}
+
+////////////////////////////////
+func cacheStore(dir string, code string, pool *poolType) (error) {
+    file, err := cacheFile(dir, code)
+    if err != nil {
+        return err
+    }
+    data, err := bcEncode(pool.bc, pool.abi, code)
+    if err != nil {
+        return err
+    }
+    err = os.MkdirAll(dir, 0755)
+    if err != nil {
+        return fmt.Errorf("%s @cacheStore", err.Error())
+    }
+    f, err := os.CreateTemp(dir, "tmp-*")
+    if err != nil {
+        return fmt.Errorf("%s @cacheStore", err.Error())
+    }
+    _, err = f.Write(data)
+    if errClose := f.Close(); err == nil {
+        err = errClose
+    }
+    if err == nil {
+        err = os.Rename(f.Name(), file)
+    }
+    if err != nil {
+        os.Remove(f.Name())
+        return fmt.Errorf("%s @cacheStore", err.Error())
+    }
+    return nil
+}
+
+////////////////////////////////
+func CacheSandboxHash() ([]byte, error) {
+    return stateSandboxHash()
+}
+
+////////////////////////////////
+// Entries are removed when unused for longer than maxAge, or when hashSandbox is non-nil and differs from the entry's.
+// Temporary files are only removed once older than maxAge, they may belong to a store in progress.
+func CachePrune(dir string, maxAge time.Duration, hashSandbox []byte) (int, error) {
+    suffix := ""
+    if hashSandbox != nil {
+        suffix = "-" + hex.EncodeToString(hashSandbox) + cacheExt
+    }
+    list, err := os.ReadDir(dir)
+    if err != nil {
+        return 0, fmt.Errorf("%s @CachePrune", err.Error())
+    }
+    count := 0
+    for _, entry := range list {
+        name := entry.Name()
+        tmp := strings.HasPrefix(name, "tmp-")
+        if entry.IsDir() || !strings.HasSuffix(name, cacheExt) && !tmp {
+            continue
+        }
+        info, err := entry.Info()
+        if err != nil {
+            continue
+        }
+        expired := maxAge > 0 && time.Since(info.ModTime()) > maxAge
+        if !expired && (tmp || suffix == "" || strings.HasSuffix(name, suffix)) {
+            continue
+        }
+        if os.Remove(filepath.Join(dir, name)) == nil {
+            count ++
+        }
+    }
+    return count, nil
+}
@@ -0,0 +1,69 @@
+////////////////////////////////
+package lyncs
+
+import (
+    "encoding/hex"
+    "os"
+    "path/filepath"
+    "testing"
+    "time"
+)
+
+////////////////////////////////
+func TestCachePrune(t *testing.T) {
+    dir := t.TempDir()
+    code := "function init() return {} end\n"
+    s, bc, err := stateFromCode(code)
+    if err != nil {
+        t.Fatal(err)
+    }
+    abi, err := stateGetAbi(s)
+    if err != nil {
+        t.Fatal(err)
+    }
+    pool := poolNew(s, bc, abi)
+    defer poolClose(pool)
+    err = cacheStore(dir, code, pool)
+    if err != nil {
+        t.Fatal(err)
+    }
+    file, err := cacheFile(dir, code)
+    if err != nil {
+        t.Fatal(err)
+    }
+    hashSandbox, err := CacheSandboxHash()
+    if err != nil {
+        t.Fatal(err)
+    }
+    other := filepath.Join(dir, hex.EncodeToString(make([]byte, 32)) + "-" + hex.EncodeToString(make([]byte, len(hashSandbox))) + cacheExt)
+    tmp := filepath.Join(dir, "tmp-1")
+    old := time.Now().Add(-time.Hour)
+    for _, f := range []string{other, tmp} {
+        err = os.WriteFile(f, []byte{}, 0644)
+        if err != nil {
+            t.Fatal(err)
+        }
+    }
+    exists := func(f string) (bool) {
+        _, err := os.Stat(f)
+        return err == nil
+    }
+    count, err := CachePrune(dir, 0, nil)
+    if err != nil || count != 0 {
+        t.Fatalf("age-only prune removed %d %v", count, err)
+    }
+    count, err = CachePrune(dir, 0, hashSandbox)
+    if err != nil || count != 1 || exists(other) || !exists(file) || !exists(tmp) {
+        t.Fatalf("sandbox prune removed %d %v", count, err)
+    }
+    count, err = CachePrune(dir, time.Minute, hashSandbox)
+    if err != nil || count != 0 || !exists(tmp) {
+        t.Fatalf("fresh tmp removed %d %v", count, err)
+    }
+    os.Chtimes(tmp, old, old)
+    os.Chtimes(file, old, old)
+    count, err = CachePrune(dir, time.Minute, nil)
+    if err != nil || count != 2 || exists(tmp) || exists(file) {
+        t.Fatalf("expired prune removed %d %v", count, err)
+    }
+}
@@ -0,0 +1,588 @@
+
+////////////////////////////////
+package main
+
+import (
+    "bufio"
+    "encoding/json"
+    "flag"
+    "fmt"
+    "os"
+    "path/filepath"
+    "strings"
+    "sync"
+    "time"
+    "github.com/kasplex/go-lyncs"
+)
+
+////////////////////////////////
+type cmdType struct {
+    name string
+    usage string
+    run func([]string) (error)
+}
+
+////////////////////////////////
+var cmdList = []cmdType{
+    {"verify", "verify [flags] <file.lua>", cmdVerify},
+    {"compile", "compile [flags] -o <out.lybc> <file.lua>", cmdCompile},
+    {"disasm", "disasm [flags] <file.lua|file.lybc>", cmdDisasm},
+    {"run", "run [flags] -fn <callback> [-session <session.json>] <file.lua|file.lybc>", cmdRun},
+    {"bench", "bench [flags] -fn <callback> [-session <session.json>] [-n <count>] [-profile <out.pb.gz>] <file.lua|file.lybc>", cmdBench},
+    {"test", "test [flags] -fixtures <dir> [-cover <out.lcov>] <file.lua|file.lybc>", cmdTest},
+    {"repl", "repl [flags] [-session <session.json>] [file.lua|file.lybc]", cmdRepl},
+    {"spawn", "spawn [flags] [-n <count>] <file.lua|file.lybc>", cmdSpawn},
+    {"prune", "prune [flags] [-age <duration>] [-stale] <cachedir>", cmdPrune},
+    {"debug", "debug [flags] -fn <callback> [-session <session.json>] [-addr <host:port>] <file.lua>", cmdDebug},
+}
+
+////////////////////////////////
+type cfgFlagType struct {
+    callbacks string
+    workers int
+    deterministic bool
+    debug bool
+    cache string
+    verbose bool
+}
+
+////////////////////////////////
+func main() {
+    if len(os.Args) < 2 {
+        usage()
+        os.Exit(2)
+    }
+    for _, cmd := range cmdList {
+        if cmd.name != os.Args[1] {
+            continue
+        }
+        err := cmd.run(os.Args[2:])
+        if err != nil {
+            fmt.Fprintln(os.Stderr, "lyncs " + cmd.name + ": " + err.Error())
+            os.Exit(1)
+        }
+        return
+    }
+    usage()
+    os.Exit(2)
+}
+
+////////////////////////////////
+func usage() {
+    fmt.Fprintln(os.Stderr, "usage: lyncs <command> [flags] <file>")
+    for _, cmd := range cmdList {
+        fmt.Fprintln(os.Stderr, "    lyncs " + cmd.usage)
+    }
+    fmt.Fprintln(os.Stderr, "common flags: -callbacks init,run -workers 8 -deterministic -debug -cache <dir> -v")
+}
+
+////////////////////////////////
+func newFlagSet(name string) (*flag.FlagSet, *cfgFlagType) {
+    fs := flag.NewFlagSet(name, flag.ExitOnError)
+    cfg := &cfgFlagType{}
+    fs.StringVar(&cfg.callbacks, "callbacks", "init,run", "comma separated callback names")
+    fs.IntVar(&cfg.workers, "workers", 8, "number of workers")
+    fs.BoolVar(&cfg.deterministic, "deterministic", true, "deterministic bytecode")
+    fs.BoolVar(&cfg.debug, "debug", false, "enable print")
+    fs.StringVar(&cfg.cache, "cache", "", "bytecode cache directory")
+    fs.BoolVar(&cfg.verbose, "v", false, "show informational diagnostics")
+    return fs, cfg
+}
+
+////////////////////////////////
+func applyConfig(fs *flag.FlagSet, cfg *cfgFlagType) (string, error) {
+    if fs.NArg() != 1 {
+        return "", fmt.Errorf("expected one input file")
+    }
+    lyncs.Config(newConfig(cfg))
+    return fs.Arg(0), nil
+}
+
+////////////////////////////////
+func newConfig(cfg *cfgFlagType) (*lyncs.ConfigType) {
+    return &lyncs.ConfigType{
+        NumWorkers: cfg.workers,
+        Callbacks: strings.Split(cfg.callbacks, ","),
+        Deterministic: cfg.deterministic,
+        Debug: cfg.debug,
+        CacheDir: cfg.cache,
+    }
+}
+
+////////////////////////////////
+func loadPool(name string, file string) (error) {
+    data, err := os.ReadFile(file)
+    if err != nil {
+        return err
+    }
+    if lyncs.BCIsContainer(data) {
+        return lyncs.PoolFromBC(name, data)
+    }
+    _, err = verifyCode(string(data), false)
+    if err != nil {
+        return err
+    }
+    return lyncs.PoolFromCode(name, string(data))
+}
+
+////////////////////////////////
+func loadSession(file string) (*lyncs.DataSessionType, error) {
+    session := &lyncs.DataSessionType{}
+    if file == "" {
+        return session, nil
+    }
+    data, err := os.ReadFile(file)
+    if err != nil {
+        return nil, err
+    }
+    err = json.Unmarshal(data, session)
+    if err != nil {
+        return nil, err
+    }
+    return session, nil
+}
+
+////////////////////////////////
+func verifyCode(code string, verbose bool) ([]byte, error) {
+    data, diags, err := lyncs.CodeVerifyDiag(code)
+    for _, diag := range diags {
+        if diag.Level == "info" && !verbose {
+            continue
+        }
+        fmt.Fprintf(os.Stderr, "%d: %s: %s [%s]\n", diag.Line, diag.Level, diag.Message, diag.Code)
+    }
+    return data, err
+}
+
+////////////////////////////////
+func cmdVerify(args []string) (error) {
+    fs, cfg := newFlagSet("verify")
+    fs.Parse(args)
+    file, err := applyConfig(fs, cfg)
+    if err != nil {
+        return err
+    }
+    code, err := os.ReadFile(file)
+    if err != nil {
+        return err
+    }
+    data, err := verifyCode(string(code), cfg.verbose)
+    if err != nil {
+        return err
+    }
+    c, err := lyncs.BCDecode(data)
+    if err != nil {
+        return err
+    }
+    fmt.Printf("ok %s bytecode=%d sha256=%x\n", file, len(c.Bytecode), c.BCHash)
+    return nil
+}
+
+////////////////////////////////
+func cmdCompile(args []string) (error) {
+    fs, cfg := newFlagSet("compile")
+    output := fs.String("o", "", "output file")
+    debugInfo := fs.String("g", "", "debug info output file")
+    fs.Parse(args)
+    file, err := applyConfig(fs, cfg)
+    if err != nil {
+        return err
+    }
+    if *output == "" {
+        *output = strings.TrimSuffix(file, ".lua") + ".lybc"
+    }
+    code, err := os.ReadFile(file)
+    if err != nil {
+        return err
+    }
+    data, err := verifyCode(string(code), cfg.verbose)
+    if err != nil {
+        return err
+    }
+    err = os.WriteFile(*output, data, 0644)
+    if err != nil {
+        return err
+    }
+    if *debugInfo != "" {
+        info, err := lyncs.CodeDebugInfo(string(code))
+        if err != nil {
+            return err
+        }
+        dataInfo, err := json.Marshal(info)
+        if err != nil {
+            return err
+        }
+        err = os.WriteFile(*debugInfo, dataInfo, 0644)
+        if err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+////////////////////////////////
+func cmdDisasm(args []string) (error) {
+    fs, cfg := newFlagSet("disasm")
+    fs.Parse(args)
+    file, err := applyConfig(fs, cfg)
+    if err != nil {
+        return err
+    }
+    data, err := os.ReadFile(file)
+    if err != nil {
+        return err
+    }
+    if !lyncs.BCIsContainer(data) && (len(data) < 3 || string(data[:3]) != "\x1bLJ") {
+        data, err = lyncs.CodeVerify(string(data))
+        if err != nil {
+            return err
+        }
+    }
+    result, err := lyncs.Disassemble(data)
+    if err != nil {
+        return err
+    }
+    fmt.Print(result.Listing)
+    return nil
+}
+
+////////////////////////////////
+func cmdRun(args []string) (error) {
+    fs, cfg := newFlagSet("run")
+    fn := fs.String("fn", "run", "callback name")
+    fileSession := fs.String("session", "", "session json file")
+    fs.Parse(args)
+    file, err := applyConfig(fs, cfg)
+    if err != nil {
+        return err
+    }
+    session, err := loadSession(*fileSession)
+    if err != nil {
+        return err
+    }
+    err = loadPool("cli", file)
+    if err != nil {
+        return err
+    }
+    defer lyncs.PoolDestroy("cli")
+    result, err := lyncs.PoolCallFunc("cli", *fn, session)
+    if err != nil {
+        return err
+    }
+    data, err := json.MarshalIndent(result, "", "    ")
+    if err != nil {
+        return err
+    }
+    fmt.Println(string(data))
+    return nil
+}
+
+////////////////////////////////
+func cmdSpawn(args []string) (error) {
+    fs, cfg := newFlagSet("spawn")
+    count := fs.Int("n", 200, "number of states")
+    fs.Parse(args)
+    file, err := applyConfig(fs, cfg)
+    if err != nil {
+        return err
+    }
+    for _, snapshot := range []bool{false, true} {
+        c := newConfig(cfg)
+        c.NumWorkers = *count
+        c.Snapshot = snapshot
+        lyncs.Config(c)
+        err = loadPool("cli", file)
+        if err != nil {
+            return err
+        }
+        start := time.Now()
+        err = lyncs.PoolWarm("cli", *count)
+        elapsed := time.Since(start)
+        lyncs.PoolDestroy("cli")
+        if err != nil {
+            return err
+        }
+        mode := "bytecode"
+        if snapshot {
+            mode = "snapshot"
+        }
+        fmt.Printf("%s: %d states, %v/state\n", mode, *count, elapsed / time.Duration(*count))
+    }
+    return nil
+}
+
+////////////////////////////////
+func cmdPrune(args []string) (error) {
+    fs, cfg := newFlagSet("prune")
+    age := fs.Duration("age", 0, "remove entries not used within this duration")
+    stale := fs.Bool("stale", false, "remove entries built for a sandbox other than this binary's")
+    fs.Parse(args)
+    dir, err := applyConfig(fs, cfg)
+    if err != nil {
+        return err
+    }
+    var hashSandbox []byte
+    if *stale {
+        hashSandbox, err = lyncs.CacheSandboxHash()
+        if err != nil {
+            return err
+        }
+    }
+    count, err := lyncs.CachePrune(dir, *age, hashSandbox)
+    if err != nil {
+        return err
+    }
+    fmt.Printf("removed %d entries\n", count)
+    return nil
+}
+
+////////////////////////////////
+func cmdDebug(args []string) (error) {
+    fs, cfg := newFlagSet("debug")
+    fn := fs.String("fn", "run", "callback name")
+    fileSession := fs.String("session", "", "session json file")
+    addr := fs.String("addr", "127.0.0.1:4711", "debug adapter listen address")
+    fs.Parse(args)
+    file, err := applyConfig(fs, cfg)
+    if err != nil {
+        return err
+    }
+    session, err := loadSession(*fileSession)
+    if err != nil {
+        return err
+    }
+    err = loadPool("cli", file)
+    if err != nil {
+        return err
+    }
+    defer lyncs.PoolDestroy("cli")
+    path, err := filepath.Abs(file)
+    if err != nil {
+        return err
+    }
+    listen, err := lyncs.PoolDebugStart("cli", *addr, path)
+    if err != nil {
+        return err
+    }
+    defer lyncs.PoolDebugStop("cli")
+    fmt.Fprintln(os.Stderr, "waiting for debugger on " + listen.String())
+    err = lyncs.PoolDebugWait("cli")
+    if err != nil {
+        return err
+    }
+    result, err := lyncs.PoolCallFunc("cli", *fn, session)
+    if err != nil {
+        return err
+    }
+    data, err := json.MarshalIndent(result, "", "    ")
+    if err != nil {
+        return err
+    }
+    fmt.Println(string(data))
+    return nil
+}
+
+////////////////////////////////
+func cmdBench(args []string) (error) {
+    fs, cfg := newFlagSet("bench")
+    fn := fs.String("fn", "run", "callback name")
+    fileSession := fs.String("session", "", "session json file")
+    n := fs.Int("n", 10000, "number of calls")
+    fileProfile := fs.String("profile", "", "write pprof profile to file")
+    period := fs.Int("period", 100, "profile sampling period in instructions")
+    fs.Parse(args)
+    file, err := applyConfig(fs, cfg)
+    if err != nil {
+        return err
+    }
+    session, err := loadSession(*fileSession)
+    if err != nil {
+        return err
+    }
+    err = loadPool("cli", file)
+    if err != nil {
+        return err
+    }
+    defer lyncs.PoolDestroy("cli")
+    if *fileProfile != "" {
+        err = lyncs.PoolProfileStart("cli", *period)
+        if err != nil {
+            return err
+        }
+    }
+    var mutex sync.Mutex
+    var errFirst error
+    countErr := 0
+    wg := &sync.WaitGroup{}
+    next := make(chan int, cfg.workers)
+    start := time.Now()
+    for i := 0; i < cfg.workers; i ++ {
+        wg.Add(1)
+        go func() {
+            defer wg.Done()
+            for range next {
+                _, err := lyncs.PoolCallFunc("cli", *fn, session)
+                if err != nil {
+                    mutex.Lock()
+                    if errFirst == nil {
+                        errFirst = err
+                    }
+                    countErr ++
+                    mutex.Unlock()
+                }
+            }
+        }()
+    }
+    for i := 0; i < *n; i ++ {
+        next <- i
+    }
+    close(next)
+    wg.Wait()
+    elapsed := time.Since(start)
+    if *fileProfile != "" {
+        data, err := lyncs.PoolProfileStop("cli")
+        if err != nil {
+            return err
+        }
+        err = os.WriteFile(*fileProfile, data, 0644)
+        if err != nil {
+            return err
+        }
+    }
+    fmt.Printf("calls=%d workers=%d errors=%d time=%s ns/op=%d ops/s=%.0f\n", *n, cfg.workers, countErr, elapsed, elapsed.Nanoseconds() / int64(max(*n, 1)), float64(*n) / elapsed.Seconds())
+    if errFirst != nil {
+        return errFirst
+    }
+    return nil
+}
+
+////////////////////////////////
+func cmdTest(args []string) (error) {
+    fs, cfg := newFlagSet("test")
+    dir := fs.String("fixtures", "fixtures", "fixture directory")
+    fileCover := fs.String("cover", "", "write lcov coverage report to file")
+    fs.Parse(args)
+    file, err := applyConfig(fs, cfg)
+    if err != nil {
+        return err
+    }
+    err = loadPool("cli", file)
+    if err != nil {
+        return err
+    }
+    defer lyncs.PoolDestroy("cli")
+    if *fileCover != "" {
+        err = lyncs.PoolCoverStart("cli")
+        if err != nil {
+            return err
+        }
+    }
+    result, err := lyncs.FixtureRunDir("cli", *dir)
+    if err != nil {
+        return err
+    }
+    if *fileCover != "" {
+        data, err := lyncs.PoolCoverStop("cli", file)
+        if err != nil {
+            return err
+        }
+        err = os.WriteFile(*fileCover, data, 0644)
+        if err != nil {
+            return err
+        }
+    }
+    countFail := 0
+    for _, r := range result {
+        if len(r.Diff) == 0 {
+            if cfg.verbose {
+                fmt.Printf("ok   %s (%s)\n", r.Fixture.Name, r.Fixture.File)
+            }
+            continue
+        }
+        countFail ++
+        fmt.Printf("FAIL %s (%s)\n", r.Fixture.Name, r.Fixture.File)
+        for _, line := range r.Diff {
+            fmt.Printf("    %s\n", line)
+        }
+    }
+    fmt.Printf("fixtures=%d passed=%d failed=%d\n", len(result), len(result) - countFail, countFail)
+    if countFail > 0 {
+        return fmt.Errorf("%d fixtures failed", countFail)
+    }
+    return nil
+}
+
+////////////////////////////////
+func cmdRepl(args []string) (error) {
+    fs, cfg := newFlagSet("repl")
+    fileSession := fs.String("session", "", "session json file")
+    fs.Parse(args)
+    var data []byte
+    var err error
+    if fs.NArg() > 0 {
+        _, err = applyConfig(fs, cfg)
+        if err != nil {
+            return err
+        }
+        data, err = os.ReadFile(fs.Arg(0))
+        if err != nil {
+            return err
+        }
+    } else {
+        lyncs.Config(&lyncs.ConfigType{Callbacks: strings.Split(cfg.callbacks, ","), Debug: cfg.debug})
+    }
+    r, err := lyncs.ReplNew(data)
+    if err != nil {
+        return err
+    }
+    defer lyncs.ReplClose(r)
+    session, err := loadSession(*fileSession)
+    if err != nil {
+        return err
+    }
+    lyncs.ReplSession(r, session)
+    scanner := bufio.NewScanner(os.Stdin)
+    for {
+        fmt.Print("> ")
+        if !scanner.Scan() {
+            fmt.Println()
+            return scanner.Err()
+        }
+        line := strings.TrimSpace(scanner.Text())
+        cmd, arg, _ := strings.Cut(line, " ")
+        arg = strings.TrimSpace(arg)
+        switch cmd {
+        case "":
+        case ":q", ":quit":
+            return nil
+        case ":help":
+            fmt.Println(":call <callback>    call a callback with the current session")
+            fmt.Println(":session <file>     load a session from json")
+            fmt.Println(":quit               exit")
+        case ":session":
+            session, err = loadSession(arg)
+            if err != nil {
+                fmt.Println("error:", err)
+                continue
+            }
+            lyncs.ReplSession(r, session)
+        case ":call":
+            result, err := lyncs.ReplCall(r, arg)
+            if err != nil {
+                fmt.Println("error:", err)
+                continue
+            }
+            data, _ := json.MarshalIndent(result, "", "    ")
+            fmt.Println(string(data))
+        default:
+            v, err := lyncs.ReplEval(r, line)
+            if err != nil {
+                fmt.Println("error:", err)
+                continue
+            }
+            if v != "" {
+                fmt.Println(v)
+            }
+        }
+    }
+}
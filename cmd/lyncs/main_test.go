@@ -0,0 +1,18 @@
+////////////////////////////////
+package main
+
+import (
+    "testing"
+)
+
+////////////////////////////////
+func TestCmdTest(t *testing.T) {
+    err := cmdTest([]string{"-v", "-fixtures", "../../testdata/fixtures/pass", "../../testdata/contract.lua"})
+    if err != nil {
+        t.Fatal(err)
+    }
+    err = cmdTest([]string{"-fixtures", "../../testdata/fixtures/fail", "../../testdata/contract.lua"})
+    if err == nil || err.Error() != "4 fixtures failed" {
+        t.Errorf("failing fixtures returned %v", err)
+    }
+}
@@ -0,0 +1,184 @@
+////////////////////////////////
+package lyncs
+
+import (
+    "bytes"
+    "crypto/sha256"
+    "encoding/binary"
+    "encoding/json"
+    "fmt"
+)
+
+////////////////////////////////
+const bcMagic = "LYBC"
+const bcFormat = 1
+
+////////////////////////////////
+type BCContainerType struct {
+    Format uint16
+    JitVersion string
+    SandboxHash []byte
+    SourceHash []byte
+    BCHash []byte
+    Abi AbiType
+    Bytecode []byte
+}
+
+////////////////////////////////
+func bcEncode(bc []byte, abi AbiType, code string) ([]byte, error) {
+    hashSandbox, err := stateSandboxHash()
+    if err != nil {
+        return nil, err
+    }
+    dataAbi, err := json.Marshal(abi)
+    if err != nil {
+        return nil, err
+    }
+    hashSource := sha256.Sum256([]byte(code))
+    hashBC := sha256.Sum256(bc)
+    data := make([]byte, 0, len(bc) + len(dataAbi) + 256)
+    data = append(data, bcMagic...)
+    data = binary.LittleEndian.AppendUint16(data, bcFormat)
+    for _, field := range [][]byte{[]byte(stateJitVersion()), hashSandbox, hashSource[:], dataAbi, hashBC[:], bc} {
+        data = binary.AppendUvarint(data, uint64(len(field)))
+        data = append(data, field...)
+    }
+    return data, nil
+}
+
+////////////////////////////////
+func BCIsContainer(data []byte) (bool) {
+    return len(data) >= len(bcMagic) && string(data[:len(bcMagic)]) == bcMagic
+}
+
+////////////////////////////////
+func BCDecode(data []byte) (*BCContainerType, error) {
+    if len(data) < len(bcMagic) + 2 || string(data[:len(bcMagic)]) != bcMagic {
+        return nil, fmt.Errorf("invalid magic @BCDecode")
+    }
+    data = data[len(bcMagic):]
+    c := &BCContainerType{}
+    c.Format = binary.LittleEndian.Uint16(data)
+    if c.Format != bcFormat {
+        return nil, fmt.Errorf("unsupported format @BCDecode")
+    }
+    data = data[2:]
+    field := make([][]byte, 6)
+    for i := range field {
+        n, lenN := binary.Uvarint(data)
+        if lenN <= 0 || n > uint64(len(data) - lenN) {
+            return nil, fmt.Errorf("truncated data @BCDecode")
+        }
+        field[i] = data[lenN:lenN+int(n)]
+        data = data[lenN+int(n):]
+    }
+    if len(data) > 0 {
+        return nil, fmt.Errorf("trailing data @BCDecode")
+    }
+    c.JitVersion = string(field[0])
+    c.SandboxHash = field[1]
+    c.SourceHash = field[2]
+    err := json.Unmarshal(field[3], &c.Abi)
+    if err != nil {
+        return nil, fmt.Errorf("invalid abi @BCDecode")
+    }
+    c.BCHash = field[4]
+    c.Bytecode = field[5]
+    hashBC := sha256.Sum256(c.Bytecode)
+    if !bytes.Equal(c.BCHash, hashBC[:]) {
+        return nil, fmt.Errorf("bytecode hash mismatch @BCDecode")
+    }
+    return c, nil
+}
+
+////////////////////////////////
+func bcCheck(c *BCContainerType) (error) {
+    if c.JitVersion != stateJitVersion() {
+        return fmt.Errorf("luajit version mismatch @bcCheck")
+    }
+    hashSandbox, err := stateSandboxHash()
+    if err != nil {
+        return err
+    }
+    if !bytes.Equal(c.SandboxHash, hashSandbox) {
+        return fmt.Errorf("sandbox hash mismatch @bcCheck")
+    }
+    return abiVerify(c.Abi)
+}
+
+////////////////////////////////
+func bcCheckAbi(c *BCContainerType, abi AbiType) (error) {
+    dataAbi1, err := json.Marshal(c.Abi)
+    if err != nil {
+        return err
+    }
+    dataAbi2, err := json.Marshal(abi)
+    if err != nil {
+        return err
+    }
+    if !bytes.Equal(dataAbi1, dataAbi2) {
+        return fmt.Errorf("abi mismatch @bcCheckAbi")
+    }
+    return nil
+}
+
+////////////////////////////////
+type BCDebugVarType struct {
+    Name string
+    StartPC int
+    EndPC int
+}
+
+////////////////////////////////
+type BCDebugProtoType struct {
+    FirstLine int
+    NumLine int
+    Lines []int
+    Upvalues []string
+    Vars []BCDebugVarType
+}
+
+////////////////////////////////
+type BCDebugType struct {
+    BCHash []byte
+    SourceHash []byte
+    Protos []BCDebugProtoType
+}
+
+////////////////////////////////
+func CodeDebugInfo(code string) (*BCDebugType, error) {
+    bc, err := stateDumpCode(code, stateDumpFlags())
+    if err != nil {
+        return nil, err
+    }
+    bcDebug, err := stateDumpCode(code, stateDumpFlags() &^ bcDumpFlagStrip)
+    if err != nil {
+        return nil, err
+    }
+    dump, err := bcParse(bcDebug)
+    if err != nil {
+        return nil, err
+    }
+    hashSource := sha256.Sum256([]byte(code))
+    hashBC := sha256.Sum256(bc)
+    info := &BCDebugType{
+        BCHash: hashBC[:],
+        SourceHash: hashSource[:],
+        Protos: make([]BCDebugProtoType, 0, len(dump.protos)),
+    }
+    for _, pt := range dump.protos {
+        uvNames, vars := bcDebugNames(pt)
+        ptInfo := BCDebugProtoType{
+            FirstLine: int(pt.firstLine),
+            NumLine: int(pt.numLine),
+            Lines: bcLineInfo(pt),
+            Upvalues: uvNames,
+            Vars: make([]BCDebugVarType, 0, len(vars)),
+        }
+        for _, v := range vars {
+            ptInfo.Vars = append(ptInfo.Vars, BCDebugVarType{Name: v.name, StartPC: v.startPC, EndPC: v.endPC})
+        }
+        info.Protos = append(info.Protos, ptInfo)
+    }
+    return info, nil
+}
@@ -0,0 +1,197 @@
+////////////////////////////////
+package lyncs
+
+import (
+    "bytes"
+    "crypto/sha256"
+    "encoding/binary"
+    "encoding/hex"
+    "fmt"
+    "os"
+    "os/exec"
+    "strings"
+    "testing"
+)
+
+////////////////////////////////
+const testContainerCode = `abi = {run = {params = {n = "uint"}}}
+function init() return {} end
+function run() return {} end
+`
+
+////////////////////////////////
+func testContainerFields(t *testing.T) ([][]byte) {
+    t.Helper()
+    s, bc, err := stateFromCode(testContainerCode)
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer stateClose(s)
+    abi, err := stateGetAbi(s)
+    if err != nil {
+        t.Fatal(err)
+    }
+    data, err := bcEncode(bc, abi, testContainerCode)
+    if err != nil {
+        t.Fatal(err)
+    }
+    c, err := BCDecode(data)
+    if err != nil {
+        t.Fatal(err)
+    }
+    return [][]byte{[]byte(c.JitVersion), c.SandboxHash, c.SourceHash, []byte(`{"run":{"Params":{"n":"uint"},"Returns":null}}`), c.BCHash, c.Bytecode}
+}
+
+////////////////////////////////
+func testContainer(fields [][]byte) ([]byte) {
+    data := binary.LittleEndian.AppendUint16([]byte(bcMagic), bcFormat)
+    for _, field := range fields {
+        data = binary.AppendUvarint(data, uint64(len(field)))
+        data = append(data, field...)
+    }
+    return data
+}
+
+////////////////////////////////
+func TestBCContainerRoundTrip(t *testing.T) {
+    fields := testContainerFields(t)
+    data := testContainer(fields)
+    c, err := BCDecode(data)
+    if err != nil {
+        t.Fatal(err)
+    }
+    hashSource := sha256.Sum256([]byte(testContainerCode))
+    if c.Format != bcFormat || c.JitVersion != stateJitVersion() || !bytes.Equal(c.SourceHash, hashSource[:]) || c.Abi["run"].Params["n"] != "uint" {
+        t.Errorf("decoded %+v", c)
+    }
+    err = bcCheck(c)
+    if err != nil {
+        t.Fatal(err)
+    }
+    err = PoolFromBC("container", data)
+    if err != nil {
+        t.Fatal(err)
+    }
+    PoolDestroy("container")
+}
+
+////////////////////////////////
+func TestBCContainerReject(t *testing.T) {
+    fields := testContainerFields(t)
+    valid := testContainer(fields)
+    replace := func(i int, v []byte) ([]byte) {
+        list := append([][]byte{}, fields...)
+        list[i] = v
+        if i == 5 {
+            hash := sha256.Sum256(v)
+            list[4] = hash[:]
+        }
+        return testContainer(list)
+    }
+    format := append([]byte{}, valid...)
+    format[len(bcMagic)] = 2
+    cases := []struct {
+        name string
+        data []byte
+        err string
+    }{
+        {"raw bytecode", fields[5], "invalid magic"},
+        {"bad magic", append([]byte("LYBX"), valid[4:]...), "invalid magic"},
+        {"short", []byte(bcMagic), "invalid magic"},
+        {"format", format, "unsupported format"},
+        {"truncated varint", append(append([]byte{}, valid[:6]...), 0x80), "truncated data"},
+        {"truncated field", valid[:len(valid) - 1], "truncated data"},
+        {"oversized length", append(append([]byte{}, valid[:6]...), 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x01), "truncated data"},
+        {"trailing", append(append([]byte{}, valid...), 0), "trailing data"},
+        {"bytecode hash", replace(4, make([]byte, 32)), "bytecode hash mismatch"},
+        {"abi json", replace(3, []byte("{")), "invalid abi"},
+        {"jit version", replace(0, []byte("LuaJIT 0.0")), "luajit version mismatch"},
+        {"sandbox hash", replace(1, make([]byte, 32)), "sandbox hash mismatch"},
+        {"abi mismatch", replace(3, []byte(`{"run":{"Params":{"n":"int"},"Returns":null}}`)), "abi mismatch"},
+        {"bad bytecode", replace(5, []byte("\x1bLJ")), ""},
+    }
+    for _, c := range cases {
+        err := PoolFromBC("container", c.data)
+        if err == nil {
+            PoolDestroy("container")
+            t.Errorf("%s: accepted", c.name)
+            continue
+        }
+        if !strings.Contains(err.Error(), c.err) {
+            t.Errorf("%s: expected %q, got %v", c.name, c.err, err)
+        }
+    }
+    for i := len(bcMagic) + 2; i < len(valid); i ++ {
+        _, err := BCDecode(valid[:i])
+        if err == nil {
+            t.Fatalf("prefix %d decoded", i)
+        }
+    }
+}
+
+////////////////////////////////
+const testReproducibleCode = `local prices = {base = 10, tiers = {a = 2, b = 3, c = 5}, names = {"x", "y"}}
+local limits = {max = 1e9, min = -1, label = "limits"}
+function init() return {} end
+function run() return {state = {v = tostring(prices.base + limits.max)}, keyRules = {v = "w"}} end
+`
+
+////////////////////////////////
+func testReproducibleHash(t *testing.T) (string) {
+    t.Helper()
+    s, bc, err := stateFromCode(testReproducibleCode)
+    if err != nil {
+        t.Fatal(err)
+    }
+    stateClose(s)
+    data, err := bcEncode(bc, nil, testReproducibleCode)
+    if err != nil {
+        t.Fatal(err)
+    }
+    hash := sha256.Sum256(data)
+    return hex.EncodeToString(hash[:])
+}
+
+////////////////////////////////
+func TestCodeReproducible(t *testing.T) {
+    cfg := lRuntime.cfg
+    defer func() {
+        lRuntime.cfg = cfg
+    }()
+    deterministic := *cfg
+    deterministic.Deterministic = true
+    lRuntime.cfg = &deterministic
+    if os.Getenv("LYNCS_TEST_REPRODUCIBLE") != "" {
+        fmt.Println(testReproducibleHash(t))
+        return
+    }
+    hash := testReproducibleHash(t)
+    if again := testReproducibleHash(t); again != hash {
+        t.Errorf("second compile %s != %s", again, hash)
+    }
+    cmd := exec.Command(os.Args[0], "-test.run=^TestCodeReproducible$")
+    cmd.Env = append(os.Environ(), "LYNCS_TEST_REPRODUCIBLE=1")
+    out, err := cmd.Output()
+    if err != nil {
+        t.Fatal(err)
+    }
+    if !strings.HasPrefix(string(out), hash + "\n") {
+        t.Errorf("other process %q != %s", out, hash)
+    }
+    err = PoolFromCode("reproducible", testReproducibleCode)
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer PoolDestroy("reproducible")
+    lRuntime.Lock()
+    bc := lRuntime.poolMap["reproducible"].bc
+    lRuntime.Unlock()
+    info, err := CodeDebugInfo(testReproducibleCode)
+    if err != nil {
+        t.Fatal(err)
+    }
+    hashBC := sha256.Sum256(bc)
+    if !bytes.Equal(info.BCHash, hashBC[:]) {
+        t.Error("debug info hash differs from pool bytecode")
+    }
+}
@@ -0,0 +1,96 @@
+////////////////////////////////
+package lyncs
+
+/*
+#include "hook.h"
+*/
+import "C"
+import (
+    "fmt"
+    "sort"
+    "strings"
+    "sync"
+    "unsafe"
+)
+
+////////////////////////////////
+type coverType struct {
+    sync.Mutex
+    lines map[int]bool
+    hits map[int]int64
+}
+
+////////////////////////////////
+var coverStateMap sync.Map
+
+////////////////////////////////
+func coverNew(code string) (*coverType, error) {
+    info, err := CodeDebugInfo(code)
+    if err != nil {
+        return nil, err
+    }
+    cover := &coverType{
+        lines: make(map[int]bool),
+        hits: make(map[int]int64),
+    }
+    for i, pt := range info.Protos {
+        if i == len(info.Protos) - 1 {
+            break
+        }
+        for _, line := range pt.Lines {
+            cover.lines[line] = true
+        }
+    }
+    return cover, nil
+}
+
+////////////////////////////////
+func coverStart(cover *coverType, s *C.lua_State) {
+    coverStateMap.Store(uintptr(unsafe.Pointer(s)), cover)
+    C.luaL_coverStart(s)
+}
+
+////////////////////////////////
+func coverStop(s *C.lua_State) {
+    C.luaL_hookStop(s)
+    coverStateMap.Delete(uintptr(unsafe.Pointer(s)))
+}
+
+////////////////////////////////
+//export coverRecord
+func coverRecord(s *C.lua_State, line C.int) {
+    v, exists := coverStateMap.Load(uintptr(unsafe.Pointer(s)))
+    if !exists {
+        return
+    }
+    cover := v.(*coverType)
+    cover.Lock()
+    cover.hits[int(line)] ++
+    cover.Unlock()
+}
+
+////////////////////////////////
+func coverLCOV(cover *coverType, name string) ([]byte) {
+    cover.Lock()
+    defer cover.Unlock()
+    lines := make([]int, 0, len(cover.lines))
+    for line := range cover.lines {
+        lines = append(lines, line)
+    }
+    sort.Ints(lines)
+    w := &strings.Builder{}
+    w.WriteString("TN:\n")
+    fmt.Fprintf(w, "SF:%s\n", name)
+    countHit := 0
+    for _, line := range lines {
+        hit := cover.hits[line]
+        if hit > 0 {
+            countHit ++
+        }
+        fmt.Fprintf(w, "DA:%d,%d\n", line, hit)
+    }
+    fmt.Fprintf(w, "LF:%d\n", len(lines))
+    fmt.Fprintf(w, "LH:%d\n", countHit)
+    w.WriteString("end_of_record\n")
+    return []byte(w.String())
+}
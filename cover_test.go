@@ -0,0 +1,52 @@
+////////////////////////////////
+package lyncs
+
+import (
+    "strings"
+    "testing"
+)
+
+////////////////////////////////
+func TestCoverLines(t *testing.T) {
+    code := `function init() return {} end
+function run()
+  local n = tonumber(session.opParams.n)
+  if n > 1 then
+    n = n * 2
+  end
+  return {}
+end
+`
+    err := PoolFromCode("cover", code)
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer PoolDestroy("cover")
+    err = PoolCoverStart("cover")
+    if err != nil {
+        t.Fatal(err)
+    }
+    for i := 0; i < 3; i ++ {
+        _, err = PoolCallFunc("cover", "run", &DataSessionType{OpParams: map[string]string{"n": "1"}})
+        if err != nil {
+            t.Fatal(err)
+        }
+    }
+    lRuntime.Lock()
+    cover := lRuntime.poolMap["cover"].cover
+    lRuntime.Unlock()
+    data, err := PoolCoverStop("cover", "c.lua")
+    if err != nil {
+        t.Fatal(err)
+    }
+    for line := range cover.hits {
+        if !cover.lines[line] {
+            t.Errorf("hit on non-contract line %d", line)
+        }
+    }
+    for _, da := range []string{"DA:3,3", "DA:4,3", "DA:5,0", "DA:7,3"} {
+        if !strings.Contains(string(data), da + "\n") {
+            t.Errorf("missing %s in\n%s", da, data)
+        }
+    }
+}
@@ -0,0 +1,185 @@
+////////////////////////////////
+package lyncs
+
+import (
+    "sort"
+    "strings"
+)
+
+////////////////////////////////
+func dataKeySplit(key string) ([]string) {
+    return strings.Split(key, lRuntime.cfg.KeySep)
+}
+
+////////////////////////////////
+func dataKeyPrefix(key string) ([]string) {
+    sep := lRuntime.cfg.KeySep
+    list := []string{}
+    i := 0
+    for {
+        n := strings.Index(key[i:], sep)
+        if n < 0 {
+            break
+        }
+        i += n
+        list = append(list, key[:i])
+        i += len(sep)
+    }
+    return list
+}
+
+////////////////////////////////
+func dataKeyRules(keyRules map[string]string, rw string) ([]string) {
+    list := make([]string, 0, len(keyRules))
+    for key, rwKey := range keyRules {
+        if rw != "" && rwKey != rw {
+            continue
+        }
+        covered := false
+        for _, prefix := range dataKeyPrefix(key) {
+            rwPrefix, exists := keyRules[prefix]
+            if exists && (rw == "" || rwPrefix == rw) {
+                covered = true
+                break
+            }
+        }
+        if !covered {
+            list = append(list, key)
+        }
+    }
+    sort.Strings(list)
+    return list
+}
+
+////////////////////////////////
+func dataStateTable(v any) (DataStateType) {
+    switch t := v.(type) {
+    case DataStateType:
+        return t
+    case map[string]any:
+        return DataStateType(t)
+    }
+    return nil
+}
+
+////////////////////////////////
+func dataStateMap2(m DataStateType) (map[string]map[string]string) {
+    if m == nil {
+        return nil
+    }
+    r := make(map[string]map[string]string, len(m))
+    for k, v := range m {
+        t := dataStateTable(v)
+        if t == nil {
+            continue
+        }
+        data := make(map[string]string, len(t))
+        for k2, v2 := range t {
+            if str, ok := v2.(string); ok {
+                data[k2] = str
+            }
+        }
+        r[k] = data
+    }
+    return r
+}
+
+////////////////////////////////
+func dataStateGet(m DataStateType, path []string) (any, bool) {
+    var node any = m
+    var exists bool
+    for _, k := range path {
+        t := dataStateTable(node)
+        if t == nil {
+            return nil, false
+        }
+        node, exists = t[k]
+        if !exists {
+            return nil, false
+        }
+    }
+    return node, true
+}
+
+////////////////////////////////
+func dataStatePut(m DataStateType, path []string, v any) {
+    last := len(path) - 1
+    for _, k := range path[:last] {
+        t := dataStateTable(m[k])
+        if t == nil {
+            t = make(DataStateType, 1)
+            m[k] = t
+        }
+        m = t
+    }
+    m[path[last]] = v
+}
+
+////////////////////////////////
+func dataStateApply(m DataStateType, path []string, v any) {
+    t := dataStateTable(v)
+    if t == nil || len(t) > 0 {
+        dataStatePut(m, path, v)
+        return
+    }
+    list := make([]DataStateType, 0, len(path))
+    for _, k := range path {
+        if m == nil {
+            return
+        }
+        list = append(list, m)
+        m = dataStateTable(m[k])
+    }
+    for i := len(list) - 1; i >= 0; i -- {
+        delete(list[i], path[i])
+        if len(list[i]) > 0 {
+            break
+        }
+    }
+}
+
+////////////////////////////////
+func dataStatePick(m DataStateType, keyRules map[string]string, rw string, r DataStateType) (DataStateType) {
+    if r == nil {
+        r = make(DataStateType, len(keyRules))
+    }
+    for _, key := range dataKeyRules(keyRules, rw) {
+        path := dataKeySplit(key)
+        node, exists := dataStateGet(m, path)
+        if exists {
+            dataStatePut(r, path, node)
+        }
+    }
+    return r
+}
+
+////////////////////////////////
+func dataCallSlotRule(slot *dataCallSlotType, key string) (string) {
+    rw := slot.keyPrefix[key]
+    rwKey, exists := slot.keyRules[key]
+    if exists && rw != "w" {
+        rw = rwKey
+    }
+    for _, prefix := range dataKeyPrefix(key) {
+        if rw == "w" {
+            break
+        }
+        rwKey, exists = slot.keyRules[prefix]
+        if exists && rw != "w" {
+            rw = rwKey
+        }
+    }
+    return rw
+}
+
+////////////////////////////////
+func dataCallSlotLock(slot *dataCallSlotType, key string, rw string) {
+    if rw == "w" && slot.keyRules[key] != "w" || rw == "r" && slot.keyRules[key] == "" {
+        slot.keyRules[key] = rw
+    }
+    for _, prefix := range dataKeyPrefix(key) {
+        if rw == "w" && slot.keyPrefix[prefix] != "w" || rw == "r" && slot.keyPrefix[prefix] == "" {
+            slot.keyPrefix[prefix] = rw
+        }
+    }
+}
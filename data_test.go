@@ -0,0 +1,135 @@
+////////////////////////////////
+package lyncs
+
+import (
+    "reflect"
+    "testing"
+)
+
+////////////////////////////////
+func testKey(path ...string) (string) {
+    key := ""
+    for i, k := range path {
+        if i > 0 {
+            key += lRuntime.cfg.KeySep
+        }
+        key += k
+    }
+    return key
+}
+
+////////////////////////////////
+func TestDataKeyRules(t *testing.T) {
+    keyRules := map[string]string{
+        testKey("a"): "w",
+        testKey("a", "b"): "w",
+        testKey("a", "c"): "r",
+        testKey("d", "e"): "r",
+        testKey("d", "e", "f"): "r",
+    }
+    cases := []struct {
+        rw string
+        expect []string
+    }{
+        {"", []string{testKey("a"), testKey("d", "e")}},
+        {"w", []string{testKey("a")}},
+        {"r", []string{testKey("a", "c"), testKey("d", "e")}},
+    }
+    for _, c := range cases {
+        list := dataKeyRules(keyRules, c.rw)
+        if !reflect.DeepEqual(list, c.expect) {
+            t.Errorf("rw %q: %q != %q", c.rw, list, c.expect)
+        }
+    }
+}
+
+////////////////////////////////
+func TestDataCallSlotRule(t *testing.T) {
+    slot := &dataCallSlotType{keyRules: map[string]string{}, keyPrefix: map[string]string{}}
+    dataCallSlotLock(slot, testKey("a", "b"), "w")
+    dataCallSlotLock(slot, testKey("p"), "r")
+    dataCallSlotLock(slot, testKey("p", "q"), "w")
+    dataCallSlotLock(slot, testKey("x", "y", "z"), "r")
+    dataCallSlotLock(slot, testKey("x", "y"), "r")
+    cases := []struct {
+        key string
+        expect string
+    }{
+        {testKey("a", "b"), "w"},
+        {testKey("a"), "w"},
+        {testKey("a", "b", "c"), "w"},
+        {testKey("a", "c"), ""},
+        {testKey("b"), ""},
+        {testKey("p"), "w"},
+        {testKey("p", "q"), "w"},
+        {testKey("p", "q", "r"), "w"},
+        {testKey("p", "z"), "r"},
+        {testKey("x"), "r"},
+        {testKey("x", "y"), "r"},
+        {testKey("x", "y", "w"), "r"},
+        {testKey("x", "w"), ""},
+    }
+    for _, c := range cases {
+        rw := dataCallSlotRule(slot, c.key)
+        if rw != c.expect {
+            t.Errorf("key %q: %q != %q", c.key, rw, c.expect)
+        }
+    }
+    dataCallSlotLock(slot, testKey("x", "y", "z"), "w")
+    if rw := dataCallSlotRule(slot, testKey("x")); rw != "w" {
+        t.Errorf("upgraded prefix %q != w", rw)
+    }
+    dataCallSlotLock(slot, testKey("a"), "r")
+    if rw := dataCallSlotRule(slot, testKey("a", "c")); rw != "r" {
+        t.Errorf("read prefix %q != r", rw)
+    }
+    if rw := dataCallSlotRule(slot, testKey("a", "b")); rw != "w" {
+        t.Errorf("downgraded key %q != w", rw)
+    }
+}
+
+////////////////////////////////
+func TestDataStateApply(t *testing.T) {
+    m := DataStateType{}
+    dataStateApply(m, []string{"a", "b", "c"}, "1")
+    dataStateApply(m, []string{"a", "b", "d"}, "2")
+    dataStateApply(m, []string{"a", "e"}, DataStateType{"f": "3"})
+    expect := DataStateType{"a": DataStateType{"b": DataStateType{"c": "1", "d": "2"}, "e": DataStateType{"f": "3"}}}
+    if !reflect.DeepEqual(m, expect) {
+        t.Fatalf("put %v != %v", m, expect)
+    }
+    dataStateApply(m, []string{"a", "b", "c"}, DataStateType{})
+    expect = DataStateType{"a": DataStateType{"b": DataStateType{"d": "2"}, "e": DataStateType{"f": "3"}}}
+    if !reflect.DeepEqual(m, expect) {
+        t.Fatalf("delete leaf %v != %v", m, expect)
+    }
+    dataStateApply(m, []string{"a", "b", "d"}, DataStateType{})
+    expect = DataStateType{"a": DataStateType{"e": DataStateType{"f": "3"}}}
+    if !reflect.DeepEqual(m, expect) {
+        t.Fatalf("delete prunes parent %v != %v", m, expect)
+    }
+    dataStateApply(m, []string{"x", "y"}, DataStateType{})
+    if !reflect.DeepEqual(m, expect) {
+        t.Fatalf("delete missing %v != %v", m, expect)
+    }
+    dataStateApply(m, []string{"a"}, DataStateType{})
+    if len(m) != 0 {
+        t.Fatalf("delete prefix %v", m)
+    }
+}
+
+////////////////////////////////
+func TestDataStatePick(t *testing.T) {
+    m := DataStateType{"a": DataStateType{"b": "1", "c": "2"}, "d": DataStateType{"e": DataStateType{"f": "3"}}}
+    keyRules := map[string]string{testKey("a", "b"): "r", testKey("d"): "w", testKey("d", "e", "f"): "r"}
+    r := dataStatePick(m, keyRules, "", nil)
+    expect := DataStateType{"a": DataStateType{"b": "1"}, "d": DataStateType{"e": DataStateType{"f": "3"}}}
+    if !reflect.DeepEqual(r, expect) {
+        t.Errorf("pick %v != %v", r, expect)
+    }
+    r = dataStatePick(m, keyRules, "w", nil)
+    expect = DataStateType{"d": DataStateType{"e": DataStateType{"f": "3"}}}
+    if !reflect.DeepEqual(r, expect) {
+        t.Errorf("pick w %v != %v", r, expect)
+    }
+}
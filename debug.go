@@ -0,0 +1,466 @@
+////////////////////////////////
+package lyncs
+
+/*
+#include <stdlib.h>
+#include "lua.h"
+#include "hook.h"
+*/
+import "C"
+import (
+    "bufio"
+    "encoding/json"
+    "fmt"
+    "io"
+    "net"
+    "net/textproto"
+    "strconv"
+    "strings"
+    "sync"
+    "unsafe"
+)
+
+////////////////////////////////
+const (
+    debugModeContinue = iota
+    debugModePause
+    debugModeStepIn
+    debugModeStepOver
+    debugModeStepOut
+)
+
+////////////////////////////////
+type debugMessageType struct {
+    Seq int `json:"seq"`
+    Type string `json:"type"`
+    Command string `json:"command,omitempty"`
+    Arguments json.RawMessage `json:"arguments,omitempty"`
+}
+
+////////////////////////////////
+type debugCmdType struct {
+    command string
+    arguments json.RawMessage
+    reply chan any
+}
+
+////////////////////////////////
+type debuggerType struct {
+    sync.RWMutex
+    file string
+    lines map[int]bool
+    listener net.Listener
+    conn net.Conn
+    seq int
+    breakpoints map[int]bool
+    mode int
+    stepLine int
+    stepDepth int
+    paused bool
+    cmd chan *debugCmdType
+    pauseMutex sync.Mutex
+    ready chan struct{}
+    readyOnce sync.Once
+    done chan struct{}
+}
+
+////////////////////////////////
+var debugStateMap sync.Map
+
+////////////////////////////////
+func debugNew(code string, file string, addr string) (*debuggerType, error) {
+    info, err := CodeDebugInfo(code)
+    if err != nil {
+        return nil, err
+    }
+    ln, err := net.Listen("tcp", addr)
+    if err != nil {
+        return nil, err
+    }
+    dbg := &debuggerType{
+        file: file,
+        lines: make(map[int]bool),
+        listener: ln,
+        breakpoints: make(map[int]bool),
+        cmd: make(chan *debugCmdType),
+        ready: make(chan struct{}),
+        done: make(chan struct{}),
+    }
+    for _, pt := range info.Protos {
+        for _, line := range pt.Lines {
+            dbg.lines[line] = true
+        }
+    }
+    go debugServe(dbg)
+    return dbg, nil
+}
+
+////////////////////////////////
+func debugClose(dbg *debuggerType) {
+    dbg.Lock()
+    select {
+    case <-dbg.done:
+    default:
+        close(dbg.done)
+    }
+    conn := dbg.conn
+    dbg.Unlock()
+    if conn != nil {
+        debugSend(dbg, map[string]any{"type": "event", "event": "terminated"})
+        conn.Close()
+    }
+    dbg.listener.Close()
+}
+
+////////////////////////////////
+func debugStart(dbg *debuggerType, s *C.lua_State) {
+    debugStateMap.Store(uintptr(unsafe.Pointer(s)), dbg)
+    C.luaL_debugStart(s)
+}
+
+////////////////////////////////
+func debugStop(s *C.lua_State) {
+    C.luaL_hookStop(s)
+    debugStateMap.Delete(uintptr(unsafe.Pointer(s)))
+}
+
+////////////////////////////////
+//export debugRecord
+func debugRecord(s *C.lua_State, line C.int, depth C.int) {
+    v, exists := debugStateMap.Load(uintptr(unsafe.Pointer(s)))
+    if !exists {
+        return
+    }
+    dbg := v.(*debuggerType)
+    if debugShouldStop(dbg, int(line), int(depth)) == "" {
+        return
+    }
+    dbg.pauseMutex.Lock()
+    defer dbg.pauseMutex.Unlock()
+    reason := debugShouldStop(dbg, int(line), int(depth))
+    if reason == "" {
+        return
+    }
+    dbg.Lock()
+    dbg.paused = true
+    dbg.Unlock()
+    debugSend(dbg, map[string]any{"type": "event", "event": "stopped", "body": map[string]any{"reason": reason, "threadId": 1, "allThreadsStopped": true}})
+    for {
+        var cmd *debugCmdType
+        select {
+        case cmd = <-dbg.cmd:
+        case <-dbg.done:
+            return
+        }
+        var args struct {
+            FrameId int `json:"frameId"`
+            VariablesReference int `json:"variablesReference"`
+        }
+        json.Unmarshal(cmd.arguments, &args)
+        dbg.Lock()
+        switch cmd.command {
+        case "stackTrace":
+            dbg.Unlock()
+            cmd.reply <- debugStackTrace(dbg, s)
+            continue
+        case "scopes":
+            dbg.Unlock()
+            cmd.reply <- map[string]any{"scopes": []map[string]any{
+                {"name": "Locals", "variablesReference": args.FrameId * 2, "expensive": false},
+                {"name": "Upvalues", "variablesReference": args.FrameId * 2 + 1, "expensive": false},
+            }}
+            continue
+        case "variables":
+            dbg.Unlock()
+            cmd.reply <- map[string]any{"variables": debugVariables(s, args.VariablesReference / 2 - 1, args.VariablesReference % 2 == 1)}
+            continue
+        case "next":
+            dbg.mode = debugModeStepOver
+        case "stepIn":
+            dbg.mode = debugModeStepIn
+        case "stepOut":
+            dbg.mode = debugModeStepOut
+        default:
+            dbg.mode = debugModeContinue
+        }
+        dbg.stepLine = int(line)
+        dbg.stepDepth = int(depth)
+        dbg.paused = false
+        dbg.Unlock()
+        cmd.reply <- nil
+        return
+    }
+}
+
+////////////////////////////////
+func debugShouldStop(dbg *debuggerType, line int, depth int) (string) {
+    dbg.RLock()
+    defer dbg.RUnlock()
+    if dbg.conn == nil {
+        return ""
+    }
+    switch dbg.mode {
+    case debugModePause:
+        return "pause"
+    case debugModeStepIn:
+        if depth != dbg.stepDepth || line != dbg.stepLine {
+            return "step"
+        }
+    case debugModeStepOver:
+        if depth < dbg.stepDepth || depth == dbg.stepDepth && line != dbg.stepLine {
+            return "step"
+        }
+    case debugModeStepOut:
+        if depth < dbg.stepDepth {
+            return "step"
+        }
+    }
+    if dbg.breakpoints[line] {
+        return "breakpoint"
+    }
+    return ""
+}
+
+////////////////////////////////
+func debugStackTrace(dbg *debuggerType, s *C.lua_State) (map[string]any) {
+    frames := []map[string]any{}
+    var ar C.lua_Debug
+    cWhat := C.CString("Sln")
+    defer C.free(unsafe.Pointer(cWhat))
+    for level := 0; C.lua_getstack(s, C.int(level), &ar) != 0; level ++ {
+        C.lua_getinfo(s, cWhat, &ar)
+        if C.GoString(ar.what) == "C" {
+            continue
+        }
+        name := "function"
+        if ar.name != nil {
+            name = C.GoString(ar.name)
+        } else if ar.linedefined == 0 {
+            name = "main"
+        }
+        source := map[string]any{"name": dbg.file, "path": dbg.file}
+        if ar.source == nil || C.GoString(ar.source) != "=" + stateChunk {
+            src := "?"
+            if ar.source != nil {
+                src = strings.TrimLeft(C.GoString(ar.source), "=@")
+            }
+            source = map[string]any{"name": src, "presentationHint": "deemphasize"}
+        }
+        frames = append(frames, map[string]any{
+            "id": level + 1,
+            "name": name,
+            "line": int(ar.currentline),
+            "column": 1,
+            "source": source,
+        })
+    }
+    return map[string]any{"stackFrames": frames, "totalFrames": len(frames)}
+}
+
+////////////////////////////////
+func debugVariables(s *C.lua_State, level int, upvalue bool) ([]map[string]any) {
+    list := []map[string]any{}
+    var ar C.lua_Debug
+    if level < 0 || C.lua_getstack(s, C.int(level), &ar) == 0 {
+        return list
+    }
+    if upvalue {
+        cWhat := C.CString("f")
+        defer C.free(unsafe.Pointer(cWhat))
+        C.lua_getinfo(s, cWhat, &ar)
+        for i := 1; ; i ++ {
+            name := C.lua_getupvalue(s, -1, C.int(i))
+            if name == nil {
+                break
+            }
+            list = append(list, map[string]any{"name": C.GoString(name), "value": stateRepr(s, -1, make(map[unsafe.Pointer]bool), 0), "variablesReference": 0})
+            C.lua_settop(s, C.lua_gettop(s)-1)
+        }
+        C.lua_settop(s, C.lua_gettop(s)-1)
+        return list
+    }
+    for i := 1; ; i ++ {
+        name := C.lua_getlocal(s, &ar, C.int(i))
+        if name == nil {
+            break
+        }
+        k := C.GoString(name)
+        if !strings.HasPrefix(k, "(*") {
+            list = append(list, map[string]any{"name": k, "value": stateRepr(s, -1, make(map[unsafe.Pointer]bool), 0), "variablesReference": 0})
+        }
+        C.lua_settop(s, C.lua_gettop(s)-1)
+    }
+    return list
+}
+
+////////////////////////////////
+func debugServe(dbg *debuggerType) {
+    for {
+        conn, err := dbg.listener.Accept()
+        if err != nil {
+            return
+        }
+        debugConn(dbg, conn)
+    }
+}
+
+////////////////////////////////
+func debugConn(dbg *debuggerType, conn net.Conn) {
+    dbg.Lock()
+    dbg.conn = conn
+    dbg.mode = debugModeContinue
+    dbg.breakpoints = make(map[int]bool)
+    dbg.Unlock()
+    r := bufio.NewReader(conn)
+    for {
+        msg, err := debugRead(r)
+        if err != nil {
+            break
+        }
+        if !debugHandle(dbg, msg) {
+            break
+        }
+    }
+    dbg.Lock()
+    dbg.conn = nil
+    dbg.breakpoints = make(map[int]bool)
+    dbg.mode = debugModeContinue
+    paused := dbg.paused
+    dbg.Unlock()
+    if paused {
+        debugForward(dbg, "continue", nil)
+    }
+    conn.Close()
+}
+
+////////////////////////////////
+func debugRead(r *bufio.Reader) (*debugMessageType, error) {
+    header, err := textproto.NewReader(r).ReadMIMEHeader()
+    if err != nil {
+        return nil, err
+    }
+    n, err := strconv.Atoi(header.Get("Content-Length"))
+    if err != nil || n <= 0 {
+        return nil, fmt.Errorf("invalid header @debugRead")
+    }
+    data := make([]byte, n)
+    _, err = io.ReadFull(r, data)
+    if err != nil {
+        return nil, err
+    }
+    msg := &debugMessageType{}
+    err = json.Unmarshal(data, msg)
+    if err != nil {
+        return nil, err
+    }
+    return msg, nil
+}
+
+////////////////////////////////
+func debugSend(dbg *debuggerType, msg map[string]any) {
+    dbg.Lock()
+    defer dbg.Unlock()
+    if dbg.conn == nil {
+        return
+    }
+    dbg.seq ++
+    msg["seq"] = dbg.seq
+    data, err := json.Marshal(msg)
+    if err != nil {
+        return
+    }
+    fmt.Fprintf(dbg.conn, "Content-Length: %d\r\n\r\n%s", len(data), data)
+}
+
+////////////////////////////////
+func debugRespond(dbg *debuggerType, req *debugMessageType, body any, err error) {
+    msg := map[string]any{"type": "response", "request_seq": req.Seq, "command": req.Command, "success": err == nil}
+    if err != nil {
+        msg["message"] = err.Error()
+    } else if body != nil {
+        msg["body"] = body
+    }
+    debugSend(dbg, msg)
+}
+
+////////////////////////////////
+func debugForward(dbg *debuggerType, command string, arguments json.RawMessage) (any, error) {
+    dbg.Lock()
+    paused := dbg.paused
+    dbg.Unlock()
+    if !paused {
+        return nil, fmt.Errorf("not paused")
+    }
+    cmd := &debugCmdType{command: command, arguments: arguments, reply: make(chan any, 1)}
+    select {
+    case dbg.cmd <- cmd:
+    case <-dbg.done:
+        return nil, fmt.Errorf("debugger closed")
+    }
+    return <-cmd.reply, nil
+}
+
+////////////////////////////////
+func debugHandle(dbg *debuggerType, req *debugMessageType) (bool) {
+    switch req.Command {
+    case "initialize":
+        debugRespond(dbg, req, map[string]any{"supportsConfigurationDoneRequest": true}, nil)
+        debugSend(dbg, map[string]any{"type": "event", "event": "initialized"})
+    case "launch", "attach", "setExceptionBreakpoints":
+        debugRespond(dbg, req, nil, nil)
+    case "configurationDone":
+        debugRespond(dbg, req, nil, nil)
+        dbg.readyOnce.Do(func() {
+            close(dbg.ready)
+        })
+    case "setBreakpoints":
+        var args struct {
+            Breakpoints []struct {
+                Line int `json:"line"`
+            } `json:"breakpoints"`
+        }
+        json.Unmarshal(req.Arguments, &args)
+        list := []map[string]any{}
+        dbg.Lock()
+        dbg.breakpoints = make(map[int]bool, len(args.Breakpoints))
+        for _, bp := range args.Breakpoints {
+            verified := dbg.lines[bp.Line]
+            if verified {
+                dbg.breakpoints[bp.Line] = true
+            }
+            list = append(list, map[string]any{"verified": verified, "line": bp.Line})
+        }
+        dbg.Unlock()
+        debugRespond(dbg, req, map[string]any{"breakpoints": list}, nil)
+    case "threads":
+        debugRespond(dbg, req, map[string]any{"threads": []map[string]any{{"id": 1, "name": "lyncs"}}}, nil)
+    case "pause":
+        dbg.Lock()
+        dbg.mode = debugModePause
+        dbg.Unlock()
+        debugRespond(dbg, req, nil, nil)
+    case "stackTrace", "scopes", "variables":
+        body, err := debugForward(dbg, req.Command, req.Arguments)
+        debugRespond(dbg, req, body, err)
+    case "continue", "next", "stepIn", "stepOut":
+        dbg.Lock()
+        paused := dbg.paused
+        dbg.Unlock()
+        if !paused {
+            debugRespond(dbg, req, nil, fmt.Errorf("not paused"))
+            break
+        }
+        var body any
+        if req.Command == "continue" {
+            body = map[string]any{"allThreadsContinued": true}
+        }
+        debugRespond(dbg, req, body, nil)
+        debugForward(dbg, req.Command, req.Arguments)
+    case "disconnect", "terminate":
+        debugRespond(dbg, req, nil, nil)
+        return false
+    default:
+        debugRespond(dbg, req, nil, fmt.Errorf("unsupported command:%s", req.Command))
+    }
+    return true
+}
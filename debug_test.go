@@ -0,0 +1,130 @@
+////////////////////////////////
+package lyncs
+
+import (
+    "bufio"
+    "encoding/json"
+    "fmt"
+    "io"
+    "net"
+    "net/textproto"
+    "strconv"
+    "testing"
+    "time"
+)
+
+////////////////////////////////
+type testDapType struct {
+    t *testing.T
+    conn net.Conn
+    r *bufio.Reader
+    seq int
+}
+
+////////////////////////////////
+func (c *testDapType) send(command string, arguments any) {
+    c.seq ++
+    data, _ := json.Marshal(map[string]any{"seq": c.seq, "type": "request", "command": command, "arguments": arguments})
+    fmt.Fprintf(c.conn, "Content-Length: %d\r\n\r\n%s", len(data), data)
+}
+
+////////////////////////////////
+func (c *testDapType) read() (map[string]any) {
+    c.t.Helper()
+    c.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+    header, err := textproto.NewReader(c.r).ReadMIMEHeader()
+    if err != nil {
+        c.t.Fatal(err)
+    }
+    n, _ := strconv.Atoi(header.Get("Content-Length"))
+    data := make([]byte, n)
+    _, err = io.ReadFull(c.r, data)
+    if err != nil {
+        c.t.Fatal(err)
+    }
+    msg := map[string]any{}
+    json.Unmarshal(data, &msg)
+    return msg
+}
+
+////////////////////////////////
+func (c *testDapType) wait(kind string, name string) (map[string]any) {
+    c.t.Helper()
+    key := "event"
+    if kind == "response" {
+        key = "command"
+    }
+    for {
+        msg := c.read()
+        if msg["type"] == kind && msg[key] == name {
+            if kind == "response" && msg["success"] != true {
+                c.t.Fatalf("%s failed: %v", name, msg["message"])
+            }
+            body, _ := msg["body"].(map[string]any)
+            return body
+        }
+    }
+}
+
+////////////////////////////////
+func TestDebugBreakpoint(t *testing.T) {
+    code := `function init() return {} end
+function run()
+  local n = tonumber(session.opParams.n)
+  n = n + 1
+  return {}
+end
+`
+    err := PoolFromCode("dbg", code)
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer PoolDestroy("dbg")
+    addr, err := PoolDebugStart("dbg", "127.0.0.1:0", "/src/c.lua")
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer PoolDebugStop("dbg")
+    conn, err := net.Dial("tcp", addr.String())
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer conn.Close()
+    c := &testDapType{t: t, conn: conn, r: bufio.NewReader(conn)}
+    c.send("initialize", map[string]any{})
+    c.wait("event", "initialized")
+    c.send("setBreakpoints", map[string]any{"breakpoints": []map[string]any{{"line": 4}}})
+    c.send("configurationDone", nil)
+    err = PoolDebugWait("dbg")
+    if err != nil {
+        t.Fatal(err)
+    }
+    done := make(chan error, 1)
+    go func() {
+        _, err := PoolCallFunc("dbg", "run", &DataSessionType{OpParams: map[string]string{"n": "1"}})
+        done <- err
+    }()
+    c.wait("event", "stopped")
+    c.send("stackTrace", map[string]any{"threadId": 1})
+    body := c.wait("response", "stackTrace")
+    frames := body["stackFrames"].([]any)
+    top := frames[0].(map[string]any)
+    if top["line"].(float64) != 4 || top["source"].(map[string]any)["path"] != "/src/c.lua" {
+        t.Errorf("top frame %v", top)
+    }
+    for _, f := range frames[1:] {
+        source := f.(map[string]any)["source"].(map[string]any)
+        if source["path"] == "/src/c.lua" {
+            t.Errorf("non-contract frame labeled as contract: %v", f)
+        }
+    }
+    c.send("continue", map[string]any{"threadId": 1})
+    select {
+    case err = <-done:
+        if err != nil {
+            t.Fatal(err)
+        }
+    case <-time.After(5 * time.Second):
+        t.Fatal("call did not resume")
+    }
+}
@@ -0,0 +1,233 @@
+////////////////////////////////
+package lyncs
+
+import (
+    "fmt"
+    "strconv"
+    "strings"
+)
+
+////////////////////////////////
+type BCInsType struct {
+    PC int
+    Op string
+    A int
+    B int
+    C int
+    D int
+    Comment string
+}
+
+////////////////////////////////
+type BCProtoInfoType struct {
+    Index int
+    Flags uint8
+    NumParams int
+    FrameSize int
+    Upvalues []string
+    Constants []string
+    Numbers []string
+    Children []int
+    Ins []BCInsType
+}
+
+////////////////////////////////
+type BCDisasmType struct {
+    Flags uint32
+    Name string
+    Protos []*BCProtoInfoType
+    Listing string
+}
+
+////////////////////////////////
+func Disassemble(bc []byte) (*BCDisasmType, error) {
+    if BCIsContainer(bc) {
+        c, err := BCDecode(bc)
+        if err != nil {
+            return nil, err
+        }
+        bc = c.Bytecode
+    }
+    dump, err := bcParse(bc)
+    if err != nil {
+        return nil, err
+    }
+    result := &BCDisasmType{
+        Flags: dump.flags,
+        Name: dump.name,
+        Protos: make([]*BCProtoInfoType, 0, len(dump.protos)),
+    }
+    listing := &strings.Builder{}
+    for i, pt := range dump.protos {
+        info := &BCProtoInfoType{
+            Index: i,
+            Flags: pt.flags,
+            NumParams: int(pt.numParams),
+            FrameSize: int(pt.frameSize),
+            Upvalues: make([]string, 0, len(pt.uv)),
+            Constants: make([]string, 0, len(pt.kgc)),
+            Numbers: make([]string, 0, len(pt.kn)),
+            Ins: make([]BCInsType, 0, len(pt.ins)),
+        }
+        for _, uv := range pt.uv {
+            info.Upvalues = append(info.Upvalues, disasmUpvalue(uv))
+        }
+        for j := len(pt.kgc) - 1; j >= 0; j -- {
+            info.Constants = append(info.Constants, disasmKgc(&pt.kgc[j]))
+            if pt.kgc[j].kind == bcKgcChild {
+                info.Children = append(info.Children, pt.kgc[j].child)
+            }
+        }
+        for _, kn := range pt.kn {
+            info.Numbers = append(info.Numbers, disasmKnum(kn))
+        }
+        for pc, ins := range pt.ins {
+            info.Ins = append(info.Ins, disasmIns(info, pc, ins))
+        }
+        result.Protos = append(result.Protos, info)
+        disasmListing(listing, info)
+    }
+    result.Listing = listing.String()
+    return result, nil
+}
+
+////////////////////////////////
+func disasmIns(info *BCProtoInfoType, pc int, ins uint32) (BCInsType) {
+    a, b, c, d := bcInsDecode(ins)
+    if int(ins & 0xff) >= len(bcOpList) {
+        return BCInsType{PC: pc, Op: fmt.Sprintf("OP%d", ins & 0xff), A: a, B: b, C: c, D: d}
+    }
+    op := bcOpList[ins & 0xff]
+    r := BCInsType{PC: pc, Op: op.name, A: a, B: -1, C: c, D: d}
+    if op.b != bcModeNone {
+        r.B = b
+        r.D = -1
+    } else {
+        r.C = -1
+    }
+    v := d
+    if op.b != bcModeNone {
+        v = c
+    }
+    switch op.cd {
+    case bcModeStr, bcModeTab, bcModeFunc:
+        if v < len(info.Constants) {
+            r.Comment = info.Constants[v]
+        }
+    case bcModeNum:
+        if v < len(info.Numbers) {
+            r.Comment = info.Numbers[v]
+        }
+    case bcModePri:
+        r.Comment = []string{"nil", "false", "true", "?"}[min(v, 3)]
+    case bcModeUV:
+        if v < len(info.Upvalues) {
+            r.Comment = info.Upvalues[v]
+        }
+    case bcModeJump:
+        r.Comment = fmt.Sprintf("=> %04d", pc + 1 + v - bcBiasJ)
+    case bcModeLitS:
+        r.Comment = strconv.Itoa(int(int16(v)))
+    }
+    if op.a == bcModeUV && a < len(info.Upvalues) {
+        r.Comment = strings.TrimSpace(info.Upvalues[a] + " " + r.Comment)
+    }
+    return r
+}
+
+////////////////////////////////
+func disasmUpvalue(uv uint16) (string) {
+    if uv & bcUvLocal == 0 {
+        return fmt.Sprintf("uv:%d", uv)
+    }
+    s := fmt.Sprintf("local:%d", uv & 0xff)
+    if uv & bcUvImmutable != 0 {
+        s += ",immutable"
+    }
+    return s
+}
+
+////////////////////////////////
+func disasmKgc(k *bcKgcType) (string) {
+    switch k.kind {
+    case bcKgcStr:
+        return strconv.Quote(k.str)
+    case bcKgcChild:
+        return fmt.Sprintf("proto %d", k.child)
+    case bcKgcTab:
+        list := make([]string, 0, len(k.tab.array) + len(k.tab.hash))
+        for i, v := range k.tab.array {
+            if i == 0 {
+                if v != nil {
+                    list = append(list, "[0]=" + disasmKtabk(v))
+                }
+                continue
+            }
+            list = append(list, disasmKtabk(v))
+        }
+        for _, kv := range k.tab.hash {
+            list = append(list, "[" + disasmKtabk(kv[0]) + "]=" + disasmKtabk(kv[1]))
+        }
+        return "{" + strings.Join(list, ",") + "}"
+    }
+    return "?"
+}
+
+////////////////////////////////
+func disasmKtabk(v any) (string) {
+    switch t := v.(type) {
+    case nil:
+        return "nil"
+    case string:
+        return strconv.Quote(t)
+    case float64:
+        return strconv.FormatFloat(t, 'g', 14, 64)
+    }
+    return fmt.Sprint(v)
+}
+
+////////////////////////////////
+func disasmKnum(kn bcKnumType) (string) {
+    if kn.isInt {
+        return strconv.Itoa(int(kn.i))
+    }
+    return strconv.FormatFloat(kn.n, 'g', 14, 64)
+}
+
+////////////////////////////////
+func disasmListing(w *strings.Builder, info *BCProtoInfoType) {
+    flags := []string{}
+    if info.Flags & bcProtoChild != 0 {
+        flags = append(flags, "child")
+    }
+    if info.Flags & bcProtoVararg != 0 {
+        flags = append(flags, "vararg")
+    }
+    if info.Flags & bcProtoFFI != 0 {
+        flags = append(flags, "ffi")
+    }
+    fmt.Fprintf(w, "-- proto %d params=%d framesize=%d flags=%s\n", info.Index, info.NumParams, info.FrameSize, strings.Join(flags, ","))
+    for i, uv := range info.Upvalues {
+        fmt.Fprintf(w, "-- U%d %s\n", i, uv)
+    }
+    for i, k := range info.Constants {
+        fmt.Fprintf(w, "-- K%d %s\n", i, k)
+    }
+    for i, n := range info.Numbers {
+        fmt.Fprintf(w, "-- N%d %s\n", i, n)
+    }
+    for _, ins := range info.Ins {
+        operand := fmt.Sprintf("%3d", ins.A)
+        if ins.B >= 0 {
+            operand += fmt.Sprintf(" %3d %3d", ins.B, ins.C)
+        } else {
+            operand += fmt.Sprintf(" %7d", ins.D)
+        }
+        line := fmt.Sprintf("%04d  %-8s %s", ins.PC, ins.Op, operand)
+        if ins.Comment != "" {
+            line += "  ; " + ins.Comment
+        }
+        w.WriteString(line + "\n")
+    }
+    w.WriteString("\n")
+}
@@ -0,0 +1,68 @@
+////////////////////////////////
+package lyncs
+
+import (
+    "flag"
+    "os"
+    "testing"
+)
+
+////////////////////////////////
+var testUpdate = flag.Bool("update", false, "update golden files")
+
+////////////////////////////////
+func TestDisassembleGolden(t *testing.T) {
+    bc := testBC(t)
+    d, err := Disassemble(bc)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if *testUpdate {
+        err = os.WriteFile("testdata/disasm.golden", []byte(d.Listing), 0644)
+        if err != nil {
+            t.Fatal(err)
+        }
+    }
+    golden, err := os.ReadFile("testdata/disasm.golden")
+    if err != nil {
+        t.Fatal(err)
+    }
+    if d.Listing != string(golden) {
+        t.Errorf("listing mismatch:\n%s\nexpected:\n%s", d.Listing, golden)
+    }
+    if len(d.Protos) != 3 || len(d.Protos[1].Upvalues) != 1 || len(d.Protos[2].Children) != 2 {
+        t.Errorf("unexpected protos %+v", d.Protos)
+    }
+}
+
+////////////////////////////////
+func TestDisassembleContainer(t *testing.T) {
+    code, err := os.ReadFile("testdata/contract.lua")
+    if err != nil {
+        t.Fatal(err)
+    }
+    data, err := CodeVerify(string(code))
+    if err != nil {
+        t.Fatal(err)
+    }
+    d1, err := Disassemble(data)
+    if err != nil {
+        t.Fatal(err)
+    }
+    d2, err := Disassemble(testBC(t))
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(d1.Protos) != len(d2.Protos) {
+        t.Fatalf("container protos %d != %d", len(d1.Protos), len(d2.Protos))
+    }
+    for i := range d1.Protos {
+        if len(d1.Protos[i].Ins) != len(d2.Protos[i].Ins) {
+            t.Errorf("container proto %d differs", i)
+        }
+    }
+    _, err = Disassemble(data[:len(data)/2])
+    if err == nil {
+        t.Errorf("truncated container accepted")
+    }
+}
@@ -0,0 +1,262 @@
+////////////////////////////////
+package lyncs
+
+import (
+    "encoding/json"
+    "fmt"
+    "os"
+    "path/filepath"
+    "sort"
+    "strconv"
+    "strings"
+    "gopkg.in/yaml.v3"
+)
+
+////////////////////////////////
+type FixtureType struct {
+    Name string
+    File string
+    Fn string
+    Session *DataSessionType
+    Expect *DataResultType
+    Error string
+}
+
+////////////////////////////////
+type FixtureResultType struct {
+    Fixture *FixtureType
+    Result *DataResultType
+    Diff []string
+    Err error
+}
+
+////////////////////////////////
+func FixtureLoad(file string) ([]*FixtureType, error) {
+    data, err := os.ReadFile(file)
+    if err != nil {
+        return nil, err
+    }
+    var raw any
+    if strings.HasSuffix(file, ".json") {
+        err = json.Unmarshal(data, &raw)
+    } else {
+        err = yaml.Unmarshal(data, &raw)
+    }
+    if err != nil {
+        return nil, fmt.Errorf("%s: %s @FixtureLoad", file, err.Error())
+    }
+    list, isList := raw.([]any)
+    if !isList {
+        list = []any{raw}
+    }
+    fixtures := make([]*FixtureType, 0, len(list))
+    for i, item := range list {
+        item = fixtureNormalize(item)
+        fixtureStateTree(item, "session")
+        fixtureStateTree(item, "expect")
+        data, err = json.Marshal(item)
+        if err != nil {
+            return nil, err
+        }
+        f := &FixtureType{}
+        err = json.Unmarshal(data, f)
+        if err != nil {
+            return nil, fmt.Errorf("%s: %s @FixtureLoad", file, err.Error())
+        }
+        if f.Name == "" {
+            f.Name = fmt.Sprintf("%s#%d", filepath.Base(file), i)
+        }
+        if f.Fn == "" {
+            f.Fn = "run"
+        }
+        if f.Session == nil {
+            f.Session = &DataSessionType{}
+        }
+        f.File = file
+        fixtures = append(fixtures, f)
+    }
+    return fixtures, nil
+}
+
+////////////////////////////////
+func FixtureLoadDir(dir string) ([]*FixtureType, error) {
+    files := []string{}
+    err := filepath.WalkDir(dir, func(path string, d os.DirEntry, err error) (error) {
+        if err != nil {
+            return err
+        }
+        ext := filepath.Ext(path)
+        if !d.IsDir() && (ext == ".json" || ext == ".yaml" || ext == ".yml") {
+            files = append(files, path)
+        }
+        return nil
+    })
+    if err != nil {
+        return nil, err
+    }
+    sort.Strings(files)
+    fixtures := []*FixtureType{}
+    for _, file := range files {
+        list, err := FixtureLoad(file)
+        if err != nil {
+            return nil, err
+        }
+        fixtures = append(fixtures, list...)
+    }
+    return fixtures, nil
+}
+
+////////////////////////////////
+func FixtureRun(name string, f *FixtureType) (*FixtureResultType, error) {
+    r := &FixtureResultType{Fixture: f}
+    r.Result, r.Err = PoolCallFunc(name, f.Fn, f.Session)
+    if r.Err != nil {
+        if f.Error == "" {
+            return r, r.Err
+        }
+        if !strings.Contains(r.Err.Error(), f.Error) {
+            r.Diff = append(r.Diff, fmt.Sprintf("error: expected %q, got %q", f.Error, r.Err.Error()))
+        }
+        return r, nil
+    }
+    if f.Error != "" {
+        r.Diff = append(r.Diff, fmt.Sprintf("error: expected %q, got none", f.Error))
+        return r, nil
+    }
+    expect := f.Expect
+    if expect == nil {
+        expect = &DataResultType{}
+    }
+    dataExpect, err := json.Marshal(expect)
+    if err != nil {
+        return r, err
+    }
+    result := *r.Result
+    if expect.Log == "" {
+        result.Log = ""
+    }
+    if expect.StateTree == nil {
+        result.StateTree = nil
+    } else if expect.State == nil {
+        result.State = nil
+    }
+    dataResult, err := json.Marshal(&result)
+    if err != nil {
+        return r, err
+    }
+    var v1, v2 any
+    json.Unmarshal(dataExpect, &v1)
+    json.Unmarshal(dataResult, &v2)
+    r.Diff = fixtureDiff("", v1, v2, r.Diff)
+    return r, nil
+}
+
+////////////////////////////////
+func FixtureRunDir(name string, dir string) ([]*FixtureResultType, error) {
+    fixtures, err := FixtureLoadDir(dir)
+    if err != nil {
+        return nil, err
+    }
+    result := make([]*FixtureResultType, 0, len(fixtures))
+    for _, f := range fixtures {
+        r, err := FixtureRun(name, f)
+        if err != nil {
+            r.Diff = append(r.Diff, "error: " + err.Error())
+        }
+        result = append(result, r)
+    }
+    return result, nil
+}
+
+////////////////////////////////
+func fixtureNormalize(v any) (any) {
+    switch t := v.(type) {
+    case nil:
+        return nil
+    case string:
+        return t
+    case map[string]any:
+        m := make(map[string]any, len(t))
+        for k, v2 := range t {
+            m[k] = fixtureNormalize(v2)
+        }
+        return m
+    case []any:
+        list := make([]any, 0, len(t))
+        for _, v2 := range t {
+            list = append(list, fixtureNormalize(v2))
+        }
+        return list
+    case float64:
+        return strconv.FormatFloat(t, 'f', -1, 64)
+    }
+    return fmt.Sprint(v)
+}
+
+////////////////////////////////
+func fixtureStateTree(item any, key string) {
+    m, _ := item.(map[string]any)
+    for k, v := range m {
+        if !strings.EqualFold(k, key) {
+            continue
+        }
+        m2, _ := v.(map[string]any)
+        state := ""
+        for k2 := range m2 {
+            if strings.EqualFold(k2, "stateTree") {
+                return
+            }
+            if strings.EqualFold(k2, "state") {
+                state = k2
+            }
+        }
+        if state != "" {
+            m2["StateTree"] = m2[state]
+            delete(m2, state)
+        }
+    }
+}
+
+////////////////////////////////
+func fixtureDiff(path string, expect any, actual any, diff []string) ([]string) {
+    if !strings.Contains(path, ".") && fixtureEmpty(expect) && fixtureEmpty(actual) {
+        return diff
+    }
+    m1, isMap1 := expect.(map[string]any)
+    m2, isMap2 := actual.(map[string]any)
+    if isMap1 && (isMap2 || actual == nil) || isMap2 && expect == nil {
+        keys := make([]string, 0, len(m1) + len(m2))
+        for k := range m1 {
+            keys = append(keys, k)
+        }
+        for k := range m2 {
+            if _, exists := m1[k]; !exists {
+                keys = append(keys, k)
+            }
+        }
+        sort.Strings(keys)
+        for _, k := range keys {
+            diff = fixtureDiff(strings.TrimPrefix(path + "." + k, "."), m1[k], m2[k], diff)
+        }
+        return diff
+    }
+    data1, _ := json.Marshal(expect)
+    data2, _ := json.Marshal(actual)
+    if string(data1) != string(data2) {
+        diff = append(diff, fmt.Sprintf("%s: expected %s, got %s", path, data1, data2))
+    }
+    return diff
+}
+
+////////////////////////////////
+func fixtureEmpty(v any) (bool) {
+    switch t := v.(type) {
+    case nil:
+        return true
+    case map[string]any:
+        return len(t) == 0
+    case []any:
+        return len(t) == 0
+    }
+    return false
+}
@@ -0,0 +1,98 @@
+////////////////////////////////
+package lyncs
+
+import (
+    "os"
+    "strings"
+    "testing"
+)
+
+////////////////////////////////
+func TestFixtureRun(t *testing.T) {
+    code, err := os.ReadFile("testdata/contract.lua")
+    if err != nil {
+        t.Fatal(err)
+    }
+    err = PoolFromCode("fixture", string(code))
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer PoolDestroy("fixture")
+    cases := []struct {
+        file string
+        diff []string
+    }{
+        {"testdata/fixtures/pass/sum.json", []string{"", ""}},
+        {"testdata/fixtures/pass/sum.yaml", []string{"", ""}},
+        {"testdata/fixtures/fail/state.json", []string{`StateTree.total: expected "21", got "20"`}},
+        {"testdata/fixtures/fail/state.yaml", []string{`StateTree.total: expected "14", got "13"`}},
+        {"testdata/fixtures/fail/error.json", []string{`error: expected "out of gas", got "`}},
+        {"testdata/fixtures/fail/error.yaml", []string{`error: expected "out of gas", got none`}},
+    }
+    for _, c := range cases {
+        fixtures, err := FixtureLoad(c.file)
+        if err != nil {
+            t.Fatal(err)
+        }
+        if len(fixtures) != len(c.diff) {
+            t.Fatalf("%s: loaded %d fixtures, expected %d", c.file, len(fixtures), len(c.diff))
+        }
+        for i, f := range fixtures {
+            r, err := FixtureRun("fixture", f)
+            if err != nil {
+                t.Fatalf("%s: %v", f.Name, err)
+            }
+            if c.diff[i] == "" && len(r.Diff) > 0 {
+                t.Errorf("%s: unexpected diff %v", f.Name, r.Diff)
+            }
+            if c.diff[i] != "" && (len(r.Diff) != 1 || !strings.HasPrefix(r.Diff[0], c.diff[i])) {
+                t.Errorf("%s: diff %v, expected %q", f.Name, r.Diff, c.diff[i])
+            }
+        }
+    }
+}
+
+////////////////////////////////
+func TestFixtureLoad(t *testing.T) {
+    fixtures, err := FixtureLoadDir("testdata/fixtures/pass")
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(fixtures) != 4 {
+        t.Fatalf("loaded %d fixtures", len(fixtures))
+    }
+    f := fixtures[2]
+    if f.Name != "sum yaml" || f.Fn != "run" || f.Session.OpParams["n"] != "2" || f.Expect.StateTree["total"] != "13" || f.Expect.State != nil {
+        t.Errorf("yaml fixture %+v %+v", f, f.Expect)
+    }
+    dir := t.TempDir()
+    err = os.WriteFile(dir + "/bad.json", []byte(`{"name": `), 0644)
+    if err != nil {
+        t.Fatal(err)
+    }
+    _, err = FixtureLoadDir(dir)
+    if err == nil {
+        t.Error("malformed fixture accepted")
+    }
+}
+
+////////////////////////////////
+func TestFixtureDiff(t *testing.T) {
+    cases := []struct {
+        expect any
+        actual any
+        diff []string
+    }{
+        {map[string]any{"a": "1"}, map[string]any{"a": "1"}, nil},
+        {map[string]any{}, nil, nil},
+        {map[string]any{"a": "1"}, map[string]any{"a": "2"}, []string{`a: expected "1", got "2"`}},
+        {map[string]any{"a": map[string]any{"b": "1"}}, map[string]any{"a": map[string]any{"b": "1", "c": "2"}}, []string{`a.c: expected null, got "2"`}},
+        {nil, map[string]any{"a": "1"}, []string{`a: expected null, got "1"`}},
+    }
+    for i, c := range cases {
+        diff := fixtureDiff("", c.expect, c.actual, nil)
+        if strings.Join(diff, "\n") != strings.Join(c.diff, "\n") {
+            t.Errorf("case %d: diff %v, expected %v", i, diff, c.diff)
+        }
+    }
+}
@@ -0,0 +1,81 @@
+#include <string.h>
+#include "lua.h"
+#include "hook.h"
+#include "_cgo_export.h"
+
+////////////////////////////////
+int luaJIT_setmode(lua_State *s, int idx, int mode);
+
+////////////////////////////////
+static void luaL_profileHook(lua_State *s, lua_Debug *ar) {
+	profileFrame frames[PROFILE_MAX_DEPTH];
+	lua_Debug d;
+	int n = 0;
+	while (n<PROFILE_MAX_DEPTH && lua_getstack(s, n, &d)) {
+		lua_getinfo(s, "Sln", &d);
+		frames[n].source = d.source;
+		frames[n].name = d.name;
+		frames[n].linedefined = d.linedefined;
+		frames[n].currentline = d.currentline;
+		n++;
+	}
+	profileRecord(s, frames, n);
+}
+
+////////////////////////////////
+void luaL_profileStart(lua_State *s, int period) {
+	if (lua_gethook(s)==luaL_profileHook) {
+		return;
+	}
+	luaJIT_setmode(s, 0, 0);
+	lua_sethook(s, luaL_profileHook, LUA_MASKCOUNT, period);
+}
+
+////////////////////////////////
+static int luaL_hookChunk(lua_State *s, lua_Debug *ar) {
+	lua_getinfo(s, "Sl", ar);
+	return ar->source!=NULL && strcmp(ar->source, HOOK_CHUNK)==0;
+}
+
+////////////////////////////////
+static void luaL_coverHook(lua_State *s, lua_Debug *ar) {
+	if (luaL_hookChunk(s, ar)) {
+		coverRecord(s, ar->currentline);
+	}
+}
+
+////////////////////////////////
+void luaL_coverStart(lua_State *s) {
+	if (lua_gethook(s)==luaL_coverHook) {
+		return;
+	}
+	luaJIT_setmode(s, 0, 0);
+	lua_sethook(s, luaL_coverHook, LUA_MASKLINE, 0);
+}
+
+////////////////////////////////
+static void luaL_debugHook(lua_State *s, lua_Debug *ar) {
+	lua_Debug d;
+	int depth = 0;
+	if (!luaL_hookChunk(s, ar)) {
+		return;
+	}
+	while (lua_getstack(s, depth, &d)) {
+		depth++;
+	}
+	debugRecord(s, ar->currentline, depth);
+}
+
+////////////////////////////////
+void luaL_debugStart(lua_State *s) {
+	if (lua_gethook(s)==luaL_debugHook) {
+		return;
+	}
+	luaJIT_setmode(s, 0, 0);
+	lua_sethook(s, luaL_debugHook, LUA_MASKLINE, 0);
+}
+
+////////////////////////////////
+void luaL_hookStop(lua_State *s) {
+	lua_sethook(s, NULL, 0, 0);
+}
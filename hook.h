@@ -0,0 +1,24 @@
+#ifndef LYNCS_HOOK_H
+#define LYNCS_HOOK_H
+
+#include "lua.h"
+
+////////////////////////////////
+#define PROFILE_MAX_DEPTH 32
+#define HOOK_CHUNK "=lyncs"
+
+////////////////////////////////
+typedef struct {
+	const char *source;
+	const char *name;
+	int linedefined;
+	int currentline;
+} profileFrame;
+
+////////////////////////////////
+void luaL_profileStart(lua_State *s, int period);
+void luaL_coverStart(lua_State *s);
+void luaL_debugStart(lua_State *s);
+void luaL_hookStop(lua_State *s);
+
+#endif
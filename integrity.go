@@ -0,0 +1,102 @@
+////////////////////////////////
+package lyncs
+
+/*
+#include "lua.h"
+*/
+import "C"
+import (
+    "encoding/binary"
+    "hash/maphash"
+    "math"
+    "unsafe"
+)
+
+////////////////////////////////
+var integritySeed = maphash.MakeSeed()
+
+////////////////////////////////
+type integrityWalkType struct {
+    seen map[unsafe.Pointer]bool
+    globals unsafe.Pointer
+    sum uint64
+}
+
+////////////////////////////////
+// Every reachable object adds its own content hash to the sum, references only hash identity, so the result does not depend on traversal order.
+func integrityHash(s *C.lua_State) (uint64) {
+    w := &integrityWalkType{seen: make(map[unsafe.Pointer]bool)}
+    top := C.lua_gettop(s)
+    C.lua_pushvalue(s, C.LUA_GLOBALSINDEX)
+    w.globals = C.lua_topointer(s, -1)
+    integrityValue(w, s, top+1)
+    C.lua_settop(s, top)
+    return w.sum
+}
+
+////////////////////////////////
+func integrityMix(list ...uint64) (uint64) {
+    buf := make([]byte, 0, 8 * len(list))
+    for _, v := range list {
+        buf = binary.LittleEndian.AppendUint64(buf, v)
+    }
+    return maphash.Bytes(integritySeed, buf)
+}
+
+////////////////////////////////
+func integritySkip(w *integrityWalkType, s *C.lua_State, p unsafe.Pointer, i C.int) (bool) {
+    if p != w.globals || C.lua_type(s, i) != C.LUA_TSTRING {
+        return false
+    }
+    k := stateToString(s, i)
+    return k == "session" || k == "state"
+}
+
+////////////////////////////////
+func integrityValue(w *integrityWalkType, s *C.lua_State, i C.int) (uint64) {
+    t := C.lua_type(s, i)
+    switch t {
+    case C.LUA_TNIL:
+        return integrityMix(uint64(t))
+    case C.LUA_TBOOLEAN:
+        return integrityMix(uint64(t), uint64(C.lua_toboolean(s, i)))
+    case C.LUA_TNUMBER:
+        return integrityMix(uint64(t), math.Float64bits(float64(C.lua_tonumber(s, i))))
+    case C.LUA_TSTRING:
+        return integrityMix(uint64(t), maphash.String(integritySeed, stateToString(s, i)))
+    }
+    p := C.lua_topointer(s, i)
+    ref := integrityMix(uint64(t), uint64(uintptr(p)))
+    if w.seen[p] {
+        return ref
+    }
+    w.seen[p] = true
+    content := ref
+    switch t {
+    case C.LUA_TTABLE:
+        sum := uint64(0)
+        C.lua_pushnil(s)
+        for C.lua_next(s, i) != 0 {
+            top := C.lua_gettop(s)
+            if !integritySkip(w, s, p, top-1) {
+                k := integrityValue(w, s, top-1)
+                sum += integrityMix(k, integrityValue(w, s, top))
+            }
+            C.lua_settop(s, top-1)
+        }
+        content = integrityMix(ref, sum)
+    case C.LUA_TFUNCTION:
+        list := []uint64{ref}
+        for j := C.int(1); C.lua_getupvalue(s, i, j) != nil; j ++ {
+            list = append(list, integrityValue(w, s, C.lua_gettop(s)))
+            C.lua_settop(s, -2)
+        }
+        content = integrityMix(list...)
+    }
+    if C.lua_getmetatable(s, i) != 0 {
+        content = integrityMix(content, integrityValue(w, s, C.lua_gettop(s)))
+        C.lua_settop(s, -2)
+    }
+    w.sum += content
+    return ref
+}
@@ -0,0 +1,87 @@
+////////////////////////////////
+package lyncs
+
+import (
+    "testing"
+)
+
+////////////////////////////////
+func testIntegrityRun(t *testing.T, code string) (uint64, uint64, *DataResultType) {
+    t.Helper()
+    s, _, err := stateFromCode(code)
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer stateClose(s)
+    before := integrityHash(s)
+    stateClean(s)
+    stateApplySession(s, &DataSessionType{})
+    err = stateCallFunc(s, "run", 1)
+    if err != nil {
+        t.Fatal(err)
+    }
+    result, err := stateGetResult(s)
+    if err != nil {
+        t.Fatal(err)
+    }
+    return before, integrityHash(s), result
+}
+
+////////////////////////////////
+func TestIntegrityOrder(t *testing.T) {
+    code := `local t = {}
+for i = 1, 20 do t["k" .. i] = i end
+local function order() local o = "" for k in pairs(t) do o = o .. k end return o end
+function init() return {} end
+function run()
+  local before = order()
+  local saved = {}
+  for k, v in pairs(t) do saved[k] = v end
+  for k in pairs(saved) do t[k] = nil end
+  for i = 1, 200 do t["x" .. i] = i end
+  for i = 1, 200 do t["x" .. i] = nil end
+  for i = 20, 1, -1 do t["k" .. i] = i end
+  return {exData = {changed = tostring(before ~= order())}}
+end
+`
+    for i := 0; i < 20; i ++ {
+        before, after, result := testIntegrityRun(t, code)
+        if result.ExData["changed"] != "true" {
+            continue
+        }
+        if before != after {
+            t.Errorf("hash depends on iteration order %x != %x", before, after)
+        }
+        return
+    }
+    t.Skip("iteration order unchanged")
+}
+
+////////////////////////////////
+func TestIntegrityMetatable(t *testing.T) {
+    cfg := lRuntime.cfg
+    sandbox := bcSandbox
+    defer func() {
+        lRuntime.cfg = cfg
+        bcSandbox = sandbox
+    }()
+    withBuiltin := *cfg
+    withBuiltin.Builtin = map[string]string{"counter": `local hidden = {n = 0}
+counter = {bump = function() hidden.n = hidden.n + 1 end}`}
+    lRuntime.cfg = &withBuiltin
+    bcSandbox = nil
+    code := `function init() return {} end
+function run() counter.bump() return {} end
+`
+    before, after, _ := testIntegrityRun(t, code)
+    if before == after {
+        t.Error("mutation behind proxy metatable not detected")
+    }
+    code = `function init() return {} end
+function run() return {} end
+`
+    before, after, _ = testIntegrityRun(t, code)
+    if before != after {
+        t.Errorf("hash changed without mutation %x != %x", before, after)
+    }
+}
@@ -0,0 +1,72 @@
+////////////////////////////////
+package lyncs
+
+import (
+    "bytes"
+    "encoding/json"
+    "log/slog"
+    "strings"
+    "testing"
+)
+
+////////////////////////////////
+func TestPoolLog(t *testing.T) {
+    cfg := lRuntime.cfg
+    sandbox := bcSandbox
+    defer func() {
+        lRuntime.cfg = cfg
+        bcSandbox = sandbox
+    }()
+    buf := &bytes.Buffer{}
+    withLog := *cfg
+    withLog.Debug = true
+    withLog.MaxLogSize = 64
+    withLog.Logger = slog.New(slog.NewJSONHandler(buf, nil))
+    lRuntime.cfg = &withLog
+    bcSandbox = nil
+    code := `function init() return {} end
+function run()
+  local n = tonumber(session.opParams.n)
+  for i = 1, n do print("a", i, true, nil, {}) end
+  if n == 0 then print("b") end
+  return {}
+end
+`
+    err := PoolFromCode("log", code)
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer PoolDestroy("log")
+    call := func(n string) (string) {
+        r, err := PoolCallFunc("log", "run", &DataSessionType{OpParams: map[string]string{"n": n}, Tx: map[string]string{"txid": "tx" + n}})
+        if err != nil {
+            t.Fatal(err)
+        }
+        return r.Log
+    }
+    line := "a\t1\ttrue\tnil\ttable\n"
+    log := call("20")
+    if len(log) != 64 || !strings.HasPrefix(log, line) {
+        t.Errorf("capped log %d %q", len(log), log)
+    }
+    if log = call("0"); log != "b\n" {
+        t.Errorf("log not reset between calls %q", log)
+    }
+    if log = call("-1"); log != "" {
+        t.Errorf("log not reset between calls %q", log)
+    }
+    records := strings.Split(strings.TrimSpace(buf.String()), "\n")
+    var last map[string]any
+    err = json.Unmarshal([]byte(records[len(records) - 1]), &last)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if last["msg"] != "b" || last["pool"] != "log" || last["fn"] != "run" || last["tx"] != "tx0" {
+        t.Errorf("log record %v", last)
+    }
+    var first map[string]any
+    json.Unmarshal([]byte(records[0]), &first)
+    if first["msg"] != strings.TrimSuffix(line, "\n") || first["tx"] != "tx20" {
+        t.Errorf("log record %v", first)
+    }
+}
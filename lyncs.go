@@ -7,6 +7,7 @@ package lyncs
 import "C"
 import (
     "fmt"
+    "slices"
     "sync"
 )
 
@@ -19,8 +20,13 @@ func init() {
         NumWorkers: 8,
         Callbacks: []string{"init", "run"},
         MaxInSlot: 128,
+        KeySep: "\x1f",
+        HeightKey: "height",
+        MaxLogSize: 65536,
+        Recycle: &RecyclePolicyType{MaxCycles: poolMaxCycle},
     }
     lRuntime.poolMap = make(map[string]*poolType)
+    lRuntime.loadMap = make(map[string]*poolLoadType)
     // ...
 }
 
@@ -35,12 +41,45 @@ func Config(cfg *ConfigType) {
     if cfg.MaxInSlot <= 0 {
         cfg.MaxInSlot = lRuntime.cfg.MaxInSlot
     }
+    if cfg.KeySep == "" {
+        cfg.KeySep = lRuntime.cfg.KeySep
+    }
+    if cfg.HeightKey == "" {
+        cfg.HeightKey = lRuntime.cfg.HeightKey
+    }
+    if cfg.MaxLogSize <= 0 {
+        cfg.MaxLogSize = lRuntime.cfg.MaxLogSize
+    }
+    if cfg.Recycle == nil {
+        cfg.Recycle = lRuntime.cfg.Recycle
+    }
     // ...
     lRuntime.cfg = cfg
 }
 
 ////////////////////////////////
 func CodeVerify(code string) ([]byte, error) {
+    data, _, err := CodeVerifyDiag(code)
+    return data, err
+}
+
+////////////////////////////////
+func CodeVerifyDiag(code string) ([]byte, []DiagnosticType, error) {
+    diags, err := CodeAnalyze(code)
+    if err != nil {
+        return nil, nil, err
+    }
+    for _, diag := range diags {
+        if diag.Level == "error" {
+            return nil, diags, fmt.Errorf("line %d: %s @CodeVerify", diag.Line, diag.Message)
+        }
+    }
+    data, err := codeVerify(code)
+    return data, diags, err
+}
+
+////////////////////////////////
+func codeVerify(code string) ([]byte, error) {
     s, bc, err := stateFromCode(code)
     if err != nil {
         return nil, err
@@ -48,14 +87,66 @@ func CodeVerify(code string) ([]byte, error) {
     defer stateClose(s)
     for _, fn := range lRuntime.cfg.Callbacks {
         if !stateCheckFunc(s, fn) {
-            return nil, fmt.Errorf("missing callback:" + fn + " @CodeVerify")
+            return nil, fmt.Errorf("missing callback:%s @CodeVerify", fn)
         }
     }
-    return bc, nil
+    abi, err := stateGetAbi(s)
+    if err != nil {
+        return nil, err
+    }
+    for fn := range abi {
+        if !slices.Contains(lRuntime.cfg.Callbacks, fn) {
+            return nil, fmt.Errorf("abi not callback:%s @CodeVerify", fn)
+        }
+    }
+    return bcEncode(bc, abi, code)
 }
 
 ////////////////////////////////
 func CallFuncParallel(callList []DataCallFuncType, stateMap map[string]map[string]string, mutex *sync.RWMutex, fCallBefore func(*DataCallFuncType), fCallAfter func(*DataCallFuncType, int, *DataResultType, error) (*DataResultType)) ([]*DataResultType) {
+    fPick := func(call *DataCallFuncType) {
+        if call.Session.State == nil {
+            call.Session.State = make(map[string]map[string]string, len(call.KeyRules))
+        }
+        for key := range call.KeyRules {
+            call.Session.State[key] = stateMap[key]
+        }
+    }
+    fApply := func(call *DataCallFuncType, r *DataResultType) {
+        for k, s := range r.State {
+            if s == nil {
+                continue
+            }
+            if len(s) == 0 {
+                stateMap[k] = nil
+                continue
+            }
+            stateMap[k] = s
+        }
+    }
+    return callFuncParallel(callList, mutex, fCallBefore, fCallAfter, fPick, fApply)
+}
+
+////////////////////////////////
+func CallFuncParallelTree(callList []DataCallFuncType, stateMap DataStateType, mutex *sync.RWMutex, fCallBefore func(*DataCallFuncType), fCallAfter func(*DataCallFuncType, int, *DataResultType, error) (*DataResultType)) ([]*DataResultType) {
+    fPick := func(call *DataCallFuncType) {
+        call.Session.StateTree = dataStatePick(stateMap, call.KeyRules, "", call.Session.StateTree)
+    }
+    fApply := func(call *DataCallFuncType, r *DataResultType) {
+        for _, key := range dataKeyRules(call.KeyRules, "w") {
+            path := dataKeySplit(key)
+            s, exists := dataStateGet(r.StateTree, path)
+            if !exists || s == nil {
+                continue
+            }
+            dataStateApply(stateMap, path, s)
+        }
+    }
+    return callFuncParallel(callList, mutex, fCallBefore, fCallAfter, fPick, fApply)
+}
+
+////////////////////////////////
+func callFuncParallel(callList []DataCallFuncType, mutex *sync.RWMutex, fCallBefore func(*DataCallFuncType), fCallAfter func(*DataCallFuncType, int, *DataResultType, error) (*DataResultType), fPick func(*DataCallFuncType), fApply func(*DataCallFuncType, *DataResultType)) ([]*DataResultType) {
     lenCall := len(callList)
     result := make([]*DataResultType, lenCall)
     iCall := 0
@@ -64,6 +155,7 @@ func CallFuncParallel(callList []DataCallFuncType, stateMap map[string]map[strin
         for i, _ := range slots {
             slots[i].list = make([]int, 0, lRuntime.cfg.MaxInSlot)
             slots[i].keyRules = make(map[string]string, lRuntime.cfg.MaxInSlot / 4)
+            slots[i].keyPrefix = make(map[string]string, lRuntime.cfg.MaxInSlot / 4)
         }
         for i := iCall; i < lenCall; i ++ {
             iSlot := 0
@@ -75,8 +167,8 @@ func CallFuncParallel(callList []DataCallFuncType, stateMap map[string]map[strin
                 conflict = false
                 rwSwitch = false
                 for key, rwCall := range callList[i].KeyRules {
-                    rwSlot, exists := slots[j].keyRules[key]
-                    if !exists {
+                    rwSlot := dataCallSlotRule(&slots[j], key)
+                    if rwSlot == "" {
                         continue
                     }
                     if rwSlot == "w" {
@@ -112,9 +204,7 @@ func CallFuncParallel(callList []DataCallFuncType, stateMap map[string]map[strin
             }
             slots[iSlot].list = append(slots[iSlot].list, i)
             for key, rwCall := range callList[i].KeyRules {
-                if rwCall == "w" && slots[iSlot].keyRules[key] != "w" || rwCall == "r" && slots[iSlot].keyRules[key] == "" {
-                    slots[iSlot].keyRules[key] = rwCall
-                }
+                dataCallSlotLock(&slots[iSlot], key, rwCall)
             }
             iCall = i + 1
         }
@@ -126,23 +216,20 @@ func CallFuncParallel(callList []DataCallFuncType, stateMap map[string]map[strin
             wg.Add(1)
             go func(i int) {
                 for _, j := range slots[i].list {
-                    if callList[j].Session.State == nil {
-                        callList[j].Session.State = make(map[string]map[string]string, len(callList[j].KeyRules))
-                    }
                     mutex.RLock()
-                    for key, _ := range callList[j].KeyRules {
-                        callList[j].Session.State[key] = stateMap[key]
-                    }
+                    fPick(&callList[j])
                     mutex.RUnlock()
                     if fCallBefore != nil {
                         fCallBefore(&callList[j])
                     }
                     r, err := PoolCallFunc(callList[j].Name, callList[j].Fn, callList[j].Session)
+                    if r != nil && len(r.StateTree) > 0 {
+                        r.StateTree = dataStatePick(r.StateTree, callList[j].KeyRules, "w", nil)
+                    }
                     if r != nil && len(r.State) > 0 {
                         for k := range r.State {
                             if callList[j].KeyRules[k] != "w" {
                                 r.State[k] = nil
-                                continue
                             }
                         }
                     }
@@ -156,20 +243,9 @@ func CallFuncParallel(callList []DataCallFuncType, stateMap map[string]map[strin
                     if r == nil {
                         continue
                     }
-                    for k, s := range r.State {
-                        if s == nil {
-                            continue
-                        }
-                        if len(s) == 0 {
-                            mutex.Lock()
-                            stateMap[k] = nil
-                            mutex.Unlock()
-                            continue
-                        }
-                        mutex.Lock()
-                        stateMap[k] = s
-                        mutex.Unlock()
-                    }
+                    mutex.Lock()
+                    fApply(&callList[j], r)
+                    mutex.Unlock()
                 }
                 wg.Done()
             }(i)
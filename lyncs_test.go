@@ -0,0 +1,141 @@
+////////////////////////////////
+package lyncs
+
+import (
+    "reflect"
+    "testing"
+)
+
+////////////////////////////////
+const testEchoCode = `
+function init() return {} end
+function run()
+  return {state = state, opParams = session.opParams, exData = session.exData}
+end
+`
+
+////////////////////////////////
+func TestStateBinarySafe(t *testing.T) {
+    s, _, err := stateFromCode(testEchoCode)
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer stateClose(s)
+    session := &DataSessionType{
+        OpParams: map[string]string{"a\x00b": "12\x00x", "\x00": "\x00\x00", "\xff\xfe": ""},
+        ExData: map[string]string{"k\x00": "\x01\x00\x02"},
+        State: map[string]map[string]string{
+            "m\x00": {"\x00k": "v\x00"},
+        },
+        StateTree: DataStateType{
+            "s\x00t": "v\x00w",
+            "n\x00": DataStateType{"\x00x": "\x00y\x00"},
+        },
+    }
+    state := DataStateType{
+        "s\x00t": "v\x00w",
+        "n\x00": DataStateType{"\x00x": "\x00y\x00"},
+        "m\x00": DataStateType{"\x00k": "v\x00"},
+    }
+    state2 := map[string]map[string]string{
+        "n\x00": {"\x00x": "\x00y\x00"},
+        "m\x00": {"\x00k": "v\x00"},
+    }
+    stateClean(s)
+    stateApplySession(s, session)
+    err = stateCallFunc(s, "run", 1)
+    if err != nil {
+        t.Fatal(err)
+    }
+    result, err := stateGetResult(s)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if !reflect.DeepEqual(result.OpParams, session.OpParams) {
+        t.Errorf("opParams %q != %q", result.OpParams, session.OpParams)
+    }
+    if !reflect.DeepEqual(result.ExData, session.ExData) {
+        t.Errorf("exData %q != %q", result.ExData, session.ExData)
+    }
+    if !reflect.DeepEqual(result.StateTree, state) {
+        t.Errorf("state tree %q != %q", result.StateTree, state)
+    }
+    if !reflect.DeepEqual(result.State, state2) {
+        t.Errorf("state %q != %q", result.State, state2)
+    }
+}
+
+////////////////////////////////
+const testCounterTreeCode = `
+function init() return {} end
+function run()
+  local h = session.opParams.h
+  local n = tonumber(state.acct and state.acct[h] or "0")
+  return {state = {acct = {[h] = tostring(n + 1)}}}
+end
+`
+
+////////////////////////////////
+const testCounterCode = `
+function init() return {} end
+function run()
+  local k = session.opParams.k
+  local n = tonumber(state[k] and state[k].n or "0")
+  return {state = {[k] = {n = tostring(n + 1)}}}
+end
+`
+
+////////////////////////////////
+func TestCallFuncParallelTree(t *testing.T) {
+    err := PoolFromCode("tree", testCounterTreeCode)
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer PoolDestroy("tree")
+    callList := make([]DataCallFuncType, 0, 40)
+    for i := 0; i < 40; i ++ {
+        h := []string{"x", "y", "z", "w"}[i%4]
+        callList = append(callList, DataCallFuncType{
+            Name: "tree",
+            Fn: "run",
+            Session: &DataSessionType{OpParams: map[string]string{"h": h}},
+            KeyRules: map[string]string{testKey("acct", h): "w"},
+        })
+    }
+    stateMap := DataStateType{}
+    result := CallFuncParallelTree(callList, stateMap, nil, nil, nil)
+    for i, r := range result {
+        if r == nil {
+            t.Fatalf("call %d: nil result", i)
+        }
+    }
+    expect := DataStateType{"acct": DataStateType{"x": "10", "y": "10", "z": "10", "w": "10"}}
+    if !reflect.DeepEqual(stateMap, expect) {
+        t.Errorf("%v != %v", stateMap, expect)
+    }
+}
+
+////////////////////////////////
+func TestCallFuncParallel(t *testing.T) {
+    err := PoolFromCode("flat", testCounterCode)
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer PoolDestroy("flat")
+    callList := make([]DataCallFuncType, 0, 30)
+    for i := 0; i < 30; i ++ {
+        k := []string{"a", "b", "c"}[i%3]
+        callList = append(callList, DataCallFuncType{
+            Name: "flat",
+            Fn: "run",
+            Session: &DataSessionType{OpParams: map[string]string{"k": k}},
+            KeyRules: map[string]string{k: "w"},
+        })
+    }
+    stateMap := map[string]map[string]string{}
+    CallFuncParallel(callList, stateMap, nil, nil, nil)
+    expect := map[string]map[string]string{"a": {"n": "10"}, "b": {"n": "10"}, "c": {"n": "10"}}
+    if !reflect.DeepEqual(stateMap, expect) {
+        t.Errorf("%v != %v", stateMap, expect)
+    }
+}
@@ -0,0 +1,32 @@
+
+////////////////////////////////
+package lyncstest
+
+import (
+    "strings"
+    "testing"
+    "github.com/kasplex/go-lyncs"
+)
+
+////////////////////////////////
+func RunFixtures(t *testing.T, name string, dir string) {
+    t.Helper()
+    fixtures, err := lyncs.FixtureLoadDir(dir)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(fixtures) == 0 {
+        t.Fatalf("no fixtures in %s", dir)
+    }
+    for _, f := range fixtures {
+        t.Run(f.Name, func(t *testing.T) {
+            r, err := lyncs.FixtureRun(name, f)
+            if err != nil {
+                t.Fatalf("%s: %s", f.File, err.Error())
+            }
+            if len(r.Diff) > 0 {
+                t.Errorf("%s:\n    %s", f.File, strings.Join(r.Diff, "\n    "))
+            }
+        })
+    }
+}
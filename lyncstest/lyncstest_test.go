@@ -0,0 +1,22 @@
+////////////////////////////////
+package lyncstest
+
+import (
+    "os"
+    "testing"
+    "github.com/kasplex/go-lyncs"
+)
+
+////////////////////////////////
+func TestRunFixtures(t *testing.T) {
+    code, err := os.ReadFile("../testdata/contract.lua")
+    if err != nil {
+        t.Fatal(err)
+    }
+    err = lyncs.PoolFromCode("lyncstest", string(code))
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer lyncs.PoolDestroy("lyncstest")
+    RunFixtures(t, "lyncstest", "../testdata/fixtures/pass")
+}
@@ -6,96 +6,905 @@ package lyncs
 import "C"
 import (
     "fmt"
+    "net"
+    "sort"
+    "strconv"
+    "strings"
     "time"
+    "unsafe"
 )
 
 ////////////////////////////////
-func poolInit(name string) (error) {
+const poolMaxCycle = 100000
+const poolMigrateFunc = "migrate"
+
+////////////////////////////////
+const (
+    poolRecycleNone = iota
+    poolRecycleCycles
+    poolRecycleMemory
+    poolRecycleAge
+    poolRecycleError
+    poolRecycleLeak
+    poolRecycleFlush
+    poolRecycleEvict
+    poolRecycleNum
+)
+
+////////////////////////////////
+var poolErrRetired = fmt.Errorf("retired pool @poolLockState")
+
+////////////////////////////////
+func poolNew(s *C.lua_State, bc []byte, abi AbiType) (*poolType) {
+    pool := &poolType{
+        idle: make(map[int64]*C.lua_State, lRuntime.cfg.NumWorkers),
+        inuse: make(map[int64]*C.lua_State, lRuntime.cfg.NumWorkers),
+        cycle: make(map[int64]int, lRuntime.cfg.NumWorkers),
+        used: make(map[int64]int64, lRuntime.cfg.NumWorkers),
+        mem: make(map[int64]int64, lRuntime.cfg.NumWorkers),
+        hash: make(map[int64]uint64, lRuntime.cfg.NumWorkers),
+        born: make(map[int64]int64, lRuntime.cfg.NumWorkers),
+        expire: make(map[int64]int, lRuntime.cfg.NumWorkers),
+        bc: bc,
+        abi: abi,
+        version: 1,
+        policy: *lRuntime.cfg.Recycle,
+        stats: &poolStatsType{},
+    }
+    if lRuntime.cfg.Snapshot {
+        var err error
+        pool.snap, err = snapshotNew(bc)
+        if err != nil && lRuntime.cfg.Logger != nil {
+            lRuntime.cfg.Logger.Warn("snapshot disabled, states spawn from bytecode", "err", err)
+        }
+    }
+    poolAdd(pool, s)
+    return pool
+}
+
+////////////////////////////////
+func poolFromCode(code string) (*poolType, error) {
+    dir := lRuntime.cfg.CacheDir
+    if dir != "" {
+        pool := cacheLoad(dir, code)
+        if pool != nil {
+            return pool, nil
+        }
+    }
+    s, bc, err := stateFromCode(code)
+    if err != nil {
+        return nil, err
+    }
+    abi, err := stateGetAbi(s)
+    if err != nil {
+        stateClose(s)
+        return nil, err
+    }
+    pool := poolNew(s, bc, abi)
+    pool.code = code
+    if dir != "" {
+        cacheStore(dir, code, pool)
+    }
+    return pool, nil
+}
+
+////////////////////////////////
+func poolFromBC(data []byte) (*poolType, error) {
+    c, err := BCDecode(data)
+    if err != nil {
+        return nil, err
+    }
+    err = bcCheck(c)
+    if err != nil {
+        return nil, err
+    }
+    bc, err := bcVerify(c.Bytecode)
+    if err != nil {
+        return nil, err
+    }
+    s, err := stateFromBC(bc)
+    if err != nil {
+        return nil, err
+    }
+    abi, err := stateGetAbi(s)
+    if err == nil {
+        err = bcCheckAbi(c, abi)
+    }
+    if err != nil {
+        stateClose(s)
+        return nil, err
+    }
+    return poolNew(s, bc, abi), nil
+}
+
+////////////////////////////////
+func poolClose(pool *poolType) {
+    for i := range pool.idle {
+        stateClose(poolDelete(pool, i))
+    }
+}
+
+////////////////////////////////
+func poolInit(name string, pool *poolType) (error) {
     if name == "" {
+        poolClose(pool)
         return fmt.Errorf("empty name @poolInit")
     }
-    lRuntime.Lock()
-    _, exists := lRuntime.poolMap[name]
-    lRuntime.Unlock()
-    if exists {
-        err := PoolDestroy(name)
-        if err != nil {
-            return err
-        }
+    err := PoolDestroy(name)
+    if err != nil {
+        poolClose(pool)
+        return err
     }
     lRuntime.Lock()
-    lRuntime.poolMap[name] = &poolType{
-        idle: make(map[int64]*C.lua_State, lRuntime.cfg.NumWorkers),
-        inuse: make(map[int64]*C.lua_State, lRuntime.cfg.NumWorkers),
-        cycle: make(map[int64]int, lRuntime.cfg.NumWorkers),
-    }
+    lRuntime.poolMap[name] = pool
     lRuntime.Unlock()
+    poolWarmAsync(pool)
+    poolEvict()
     return nil
 }
 
 ////////////////////////////////
 func PoolFromCode(name string, code string) (error) {
-    err := poolInit(name)
+    pool, err := poolFromCode(code)
     if err != nil {
         return err
     }
-    s, bc, err := stateFromCode(code)
+    return poolInit(name, pool)
+}
+
+////////////////////////////////
+func PoolFromBC(name string, data []byte) (error) {
+    pool, err := poolFromBC(data)
     if err != nil {
-        PoolDestroy(name)
         return err
     }
+    return poolInit(name, pool)
+}
+
+////////////////////////////////
+func poolUpgrade(name string, pool *poolType, session *DataSessionType, fApply func(*DataResultType) (error)) (uint64, *DataResultType, error) {
+    if name == "" {
+        poolClose(pool)
+        return 0, nil, fmt.Errorf("empty name @poolUpgrade")
+    }
+    if session == nil {
+        session = &DataSessionType{}
+    }
     lRuntime.Lock()
-    index := time.Now().UnixNano()
-    lRuntime.poolMap[name].idle[index] = s
-    lRuntime.poolMap[name].cycle[index] = 0
-    lRuntime.poolMap[name].code = code
-    lRuntime.poolMap[name].bc = bc
+    old, exists := lRuntime.poolMap[name]
+    if !exists {
+        lRuntime.poolMap[name] = pool
+        lRuntime.Unlock()
+        poolWarmAsync(pool)
+        return pool.version, nil, nil
+    }
+    height := old.height
+    value, exists := session.Block[lRuntime.cfg.HeightKey]
+    if exists {
+        var err error
+        height, err = strconv.ParseUint(value, 10, 64)
+        if err != nil || height < old.height {
+            lRuntime.Unlock()
+            poolClose(pool)
+            return 0, nil, fmt.Errorf("invalid height @poolUpgrade")
+        }
+    }
+    pool.version = poolMaxVersion(old) + 1
+    old.Lock()
+    if old.profile != nil || old.cover != nil || old.debug != nil {
+        old.Unlock()
+        lRuntime.Unlock()
+        poolClose(pool)
+        return 0, nil, fmt.Errorf("hook exists @poolUpgrade")
+    }
+    if old.retired {
+        old.Unlock()
+        lRuntime.Unlock()
+        poolClose(pool)
+        return 0, nil, fmt.Errorf("concurrent upgrade @poolUpgrade")
+    }
+    old.retired = true
+    old.swap = make(chan struct{})
+    drain := make(chan struct{})
+    if len(old.inuse) == 0 {
+        close(drain)
+    } else {
+        old.drain = drain
+    }
+    old.Unlock()
     lRuntime.Unlock()
+    <-drain
+    result, err := poolMigrate(pool, old.version, session)
+    if err == nil && result != nil && fApply != nil {
+        err = fApply(result)
+    }
+    lRuntime.Lock()
+    head := lRuntime.poolMap[name] == old
+    if err == nil && !head {
+        err = fmt.Errorf("concurrent upgrade @poolUpgrade")
+    }
+    old.Lock()
+    if err == nil {
+        pool.height = height
+        pool.prev = old
+        pool.policy = old.policy
+        pool.stats = old.stats
+        lRuntime.poolMap[name] = pool
+    }
+    var list []*C.lua_State
+    if head && (err != nil || height > old.height) {
+        old.retired = false
+    } else if err == nil {
+        list = poolFlush(old)
+    }
+    close(old.swap)
+    old.swap = nil
+    old.Unlock()
+    lRuntime.Unlock()
+    for _, s := range list {
+        stateClose(s)
+    }
+    if err != nil {
+        poolClose(pool)
+        return 0, nil, err
+    }
+    poolWarmAsync(pool)
+    return pool.version, result, nil
+}
+
+////////////////////////////////
+func poolWaitSwap(pool *poolType) {
+    pool.Lock()
+    swap := pool.swap
+    pool.Unlock()
+    if swap != nil {
+        <-swap
+    }
+}
+
+////////////////////////////////
+func poolMigrate(pool *poolType, from uint64, session *DataSessionType) (*DataResultType, error) {
+    s, index, err := poolLockState(pool)
+    if err != nil {
+        return nil, err
+    }
+    failed := false
+    defer func() {
+        poolUnlockState(pool, index, failed)
+    }()
+    if !stateCheckFunc(s, poolMigrateFunc) {
+        return nil, nil
+    }
+    failed = true
+    stateClean(s)
+    stateApplySession(s, session)
+    err = stateCallFuncNumber(s, poolMigrateFunc, []float64{float64(from), float64(pool.version)}, 1)
+    log := stateGetLog(s)
+    if err != nil {
+        return nil, err
+    }
+    result, err := stateGetResult(s)
+    if err != nil {
+        return nil, err
+    }
+    result.Log = log
+    failed = false
+    return result, nil
+}
+
+////////////////////////////////
+func PoolUpgradeFromCode(name string, code string, session *DataSessionType, fApply func(*DataResultType) (error)) (uint64, *DataResultType, error) {
+    pool, err := poolFromCode(code)
+    if err != nil {
+        return 0, nil, err
+    }
+    return poolUpgrade(name, pool, session, fApply)
+}
+
+////////////////////////////////
+func PoolUpgradeFromBC(name string, data []byte, session *DataSessionType, fApply func(*DataResultType) (error)) (uint64, *DataResultType, error) {
+    pool, err := poolFromBC(data)
+    if err != nil {
+        return 0, nil, err
+    }
+    return poolUpgrade(name, pool, session, fApply)
+}
+
+////////////////////////////////
+func poolAddVersion(name string, height uint64, pool *poolType) (uint64, error) {
+    lRuntime.Lock()
+    defer lRuntime.Unlock()
+    head, exists := lRuntime.poolMap[name]
+    if !exists {
+        poolClose(pool)
+        return 0, fmt.Errorf("empty pool @poolAddVersion")
+    }
+    pool.height = height
+    pool.version = poolMaxVersion(head) + 1
+    head.Lock()
+    pool.policy = head.policy
+    pool.stats = head.stats
+    head.Unlock()
+    if height > head.height {
+        pool.prev = head
+        lRuntime.poolMap[name] = pool
+        poolWarmAsync(pool)
+        return pool.version, nil
+    }
+    for p := head; p != nil; p = p.prev {
+        if p.height == height {
+            poolClose(pool)
+            return 0, fmt.Errorf("height exists @poolAddVersion")
+        }
+        if p.prev == nil || p.prev.height < height {
+            pool.prev = p.prev
+            p.prev = pool
+            break
+        }
+    }
+    poolWarmAsync(pool)
+    return pool.version, nil
+}
+
+////////////////////////////////
+func PoolAddVersionFromCode(name string, height uint64, code string) (uint64, error) {
+    pool, err := poolFromCode(code)
+    if err != nil {
+        return 0, err
+    }
+    return poolAddVersion(name, height, pool)
+}
+
+////////////////////////////////
+func PoolAddVersionFromBC(name string, height uint64, data []byte) (uint64, error) {
+    pool, err := poolFromBC(data)
+    if err != nil {
+        return 0, err
+    }
+    return poolAddVersion(name, height, pool)
+}
+
+////////////////////////////////
+func poolMaxVersion(head *poolType) (uint64) {
+    version := uint64(0)
+    for p := head; p != nil; p = p.prev {
+        version = max(version, p.version)
+    }
+    return version
+}
+
+////////////////////////////////
+// Coverage and debug hooks attach to the head only, older versions selected by height run unhooked.
+func poolSelect(head *poolType, session *DataSessionType) (*poolType, error) {
+    value, exists := session.Block[lRuntime.cfg.HeightKey]
+    if !exists {
+        return head, nil
+    }
+    height, err := strconv.ParseUint(value, 10, 64)
+    if err != nil {
+        return nil, fmt.Errorf("invalid height @poolSelect")
+    }
+    for p := head; p != nil; p = p.prev {
+        if p.height <= height {
+            return p, nil
+        }
+    }
+    return nil, fmt.Errorf("no version at height %d @poolSelect", height)
+}
+
+////////////////////////////////
+func PoolGetVersion(name string) (uint64, error) {
+    lRuntime.Lock()
+    defer lRuntime.Unlock()
+    pool, exists := lRuntime.poolMap[name]
+    if !exists {
+        return 0, fmt.Errorf("empty pool @PoolGetVersion")
+    }
+    return pool.version, nil
+}
+
+////////////////////////////////
+func PoolGetAbi(name string) (AbiType, error) {
+    lRuntime.Lock()
+    defer lRuntime.Unlock()
+    pool, exists := lRuntime.poolMap[name]
+    if !exists {
+        return nil, fmt.Errorf("empty pool @PoolGetAbi")
+    }
+    return pool.abi, nil
+}
+
+////////////////////////////////
+func PoolDestroy(name string) (error) {
+    lRuntime.Lock()
+    defer lRuntime.Unlock()
+    pool, exists := lRuntime.poolMap[name]
+    if !exists {
+        return nil
+    }
+    for p := pool; p != nil; p = p.prev {
+        p.Lock()
+        inuse := len(p.inuse)
+        p.Unlock()
+        if inuse > 0 {
+            return fmt.Errorf("pool exists/inuse @PoolDestroy")
+        }
+    }
+    for p := pool; p != nil; p = p.prev {
+        p.Lock()
+        p.retired = true
+        poolClose(p)
+        p.Unlock()
+    }
+    delete(lRuntime.poolMap, name)
     return nil
 }
 
 ////////////////////////////////
-func PoolFromBC(name string, bc []byte) (error) {
-    err := poolInit(name)
+func poolFlush(pool *poolType) ([]*C.lua_State) {
+    list := make([]*C.lua_State, 0, len(pool.idle))
+    for i, s := range pool.idle {
+        if _, exists := pool.inuse[i]; exists {
+            pool.expire[i] = poolRecycleFlush
+            continue
+        }
+        list = append(list, s)
+        poolDelete(pool, i)
+        pool.stats[poolRecycleFlush].Add(1)
+    }
+    return list
+}
+
+////////////////////////////////
+func poolChain(name string) ([]*poolType) {
+    lRuntime.Lock()
+    defer lRuntime.Unlock()
+    list := []*poolType{}
+    for p := lRuntime.poolMap[name]; p != nil; p = p.prev {
+        list = append(list, p)
+    }
+    return list
+}
+
+////////////////////////////////
+func PoolProfileStart(name string, period int) (error) {
+    chain := poolChain(name)
+    if len(chain) == 0 {
+        return fmt.Errorf("empty pool @PoolProfileStart")
+    }
+    if period <= 0 {
+        period = 100
+    }
+    bcDebug := make([][]byte, len(chain))
+    for i, pool := range chain {
+        if pool.code == "" {
+            continue
+        }
+        var err error
+        bcDebug[i], err = stateDumpCode(pool.code, stateDumpFlags() &^ bcDumpFlagStrip)
+        if err != nil {
+            return err
+        }
+    }
+    for _, pool := range chain {
+        pool.Lock()
+    }
+    for _, pool := range chain {
+        if pool.profile != nil || pool.cover != nil || pool.debug != nil {
+            for _, p := range chain {
+                p.Unlock()
+            }
+            return fmt.Errorf("hook exists @PoolProfileStart")
+        }
+    }
+    prof := profileNew(period)
+    list := []*C.lua_State{}
+    for i, pool := range chain {
+        pool.profile = prof
+        pool.bcDebug = bcDebug[i]
+        list = append(list, poolFlush(pool)...)
+        pool.Unlock()
+    }
+    for _, s := range list {
+        stateClose(s)
+    }
+    return nil
+}
+
+////////////////////////////////
+func PoolProfileStop(name string) ([]byte, error) {
+    chain := poolChain(name)
+    if len(chain) == 0 {
+        return nil, fmt.Errorf("empty pool @PoolProfileStop")
+    }
+    chain[0].Lock()
+    prof := chain[0].profile
+    chain[0].Unlock()
+    if prof == nil {
+        return nil, fmt.Errorf("no profile @PoolProfileStop")
+    }
+    list := []*C.lua_State{}
+    for _, pool := range chain {
+        pool.Lock()
+        if pool.profile == prof {
+            pool.profile = nil
+            pool.bcDebug = nil
+            list = append(list, poolFlush(pool)...)
+        }
+        pool.Unlock()
+    }
+    for _, s := range list {
+        stateClose(s)
+    }
+    return profileEncode(prof)
+}
+
+////////////////////////////////
+func PoolCoverStart(name string) (error) {
+    lRuntime.Lock()
+    pool, exists := lRuntime.poolMap[name]
+    lRuntime.Unlock()
+    if !exists {
+        return fmt.Errorf("empty pool @PoolCoverStart")
+    }
+    if pool.code == "" {
+        return fmt.Errorf("no source @PoolCoverStart")
+    }
+    cover, err := coverNew(pool.code)
     if err != nil {
         return err
     }
-    s, err := stateFromBC(bc)
+    bcDebug, err := stateDumpCode(pool.code, stateDumpFlags() &^ bcDumpFlagStrip)
     if err != nil {
-        PoolDestroy(name)
         return err
     }
+    pool.Lock()
+    if pool.profile != nil || pool.cover != nil || pool.debug != nil {
+        pool.Unlock()
+        return fmt.Errorf("hook exists @PoolCoverStart")
+    }
+    pool.cover = cover
+    pool.bcDebug = bcDebug
+    list := poolFlush(pool)
+    pool.Unlock()
+    for _, s := range list {
+        stateClose(s)
+    }
+    return nil
+}
+
+////////////////////////////////
+func PoolCoverStop(name string, file string) ([]byte, error) {
     lRuntime.Lock()
-    index := time.Now().UnixNano()
-    lRuntime.poolMap[name].idle[index] = s
-    lRuntime.poolMap[name].cycle[index] = 0
-    lRuntime.poolMap[name].bc = bc
+    pool, exists := lRuntime.poolMap[name]
     lRuntime.Unlock()
-    return nil
+    if !exists {
+        return nil, fmt.Errorf("empty pool @PoolCoverStop")
+    }
+    pool.Lock()
+    cover := pool.cover
+    if cover == nil {
+        pool.Unlock()
+        return nil, fmt.Errorf("no cover @PoolCoverStop")
+    }
+    pool.cover = nil
+    pool.bcDebug = nil
+    list := poolFlush(pool)
+    pool.Unlock()
+    for _, s := range list {
+        stateClose(s)
+    }
+    if file == "" {
+        file = name + ".lua"
+    }
+    return coverLCOV(cover, file), nil
 }
 
 ////////////////////////////////
-func PoolDestroy(name string) (error) {
+func PoolDebugStart(name string, addr string, file string) (net.Addr, error) {
+    lRuntime.Lock()
+    pool, exists := lRuntime.poolMap[name]
+    lRuntime.Unlock()
+    if !exists {
+        return nil, fmt.Errorf("empty pool @PoolDebugStart")
+    }
+    if pool.code == "" {
+        return nil, fmt.Errorf("no source @PoolDebugStart")
+    }
+    bcDebug, err := stateDumpCode(pool.code, stateDumpFlags() &^ bcDumpFlagStrip)
+    if err != nil {
+        return nil, err
+    }
+    pool.Lock()
+    if pool.profile != nil || pool.cover != nil || pool.debug != nil {
+        pool.Unlock()
+        return nil, fmt.Errorf("hook exists @PoolDebugStart")
+    }
+    dbg, err := debugNew(pool.code, file, addr)
+    if err != nil {
+        pool.Unlock()
+        return nil, err
+    }
+    pool.debug = dbg
+    pool.bcDebug = bcDebug
+    list := poolFlush(pool)
+    pool.Unlock()
+    for _, s := range list {
+        stateClose(s)
+    }
+    return dbg.listener.Addr(), nil
+}
+
+////////////////////////////////
+func PoolDebugWait(name string) (error) {
     lRuntime.Lock()
-    defer lRuntime.Unlock()
     pool, exists := lRuntime.poolMap[name]
+    lRuntime.Unlock()
     if !exists {
+        return fmt.Errorf("empty pool @PoolDebugWait")
+    }
+    pool.Lock()
+    dbg := pool.debug
+    pool.Unlock()
+    if dbg == nil {
+        return fmt.Errorf("no debugger @PoolDebugWait")
+    }
+    select {
+    case <-dbg.ready:
         return nil
+    case <-dbg.done:
+        return fmt.Errorf("debugger closed @PoolDebugWait")
     }
-    if len(pool.inuse) > 0 {
-        return fmt.Errorf("pool exists/inuse @PoolDestroy")
+}
+
+////////////////////////////////
+func PoolDebugStop(name string) (error) {
+    lRuntime.Lock()
+    pool, exists := lRuntime.poolMap[name]
+    lRuntime.Unlock()
+    if !exists {
+        return fmt.Errorf("empty pool @PoolDebugStop")
     }
-    for _, s := range pool.idle {
+    pool.Lock()
+    dbg := pool.debug
+    if dbg == nil {
+        pool.Unlock()
+        return fmt.Errorf("no debugger @PoolDebugStop")
+    }
+    pool.debug = nil
+    pool.bcDebug = nil
+    list := poolFlush(pool)
+    pool.Unlock()
+    debugClose(dbg)
+    for _, s := range list {
         stateClose(s)
     }
-    delete(lRuntime.poolMap, name)
     return nil
 }
 
+////////////////////////////////
+func poolStateBC(pool *poolType) ([]byte) {
+    if pool.bcDebug != nil {
+        return pool.bcDebug
+    }
+    return pool.bc
+}
+
+////////////////////////////////
+func poolNewState(pool *poolType, bc []byte) (*C.lua_State, error) {
+    if pool.snap != nil && unsafe.SliceData(bc) == unsafe.SliceData(pool.bc) {
+        return snapshotRestore(pool.snap)
+    }
+    return stateFromBC(bc)
+}
+
+////////////////////////////////
+func poolIndex(pool *poolType) (int64) {
+    i := time.Now().UnixNano()
+    for {
+        if _, exists := pool.idle[i]; !exists {
+            return i
+        }
+        i ++
+    }
+}
+
+////////////////////////////////
+func poolSpawn(pool *poolType, n int) (error) {
+    defer poolEvict()
+    for {
+        pool.Lock()
+        if pool.retired || len(pool.idle) - len(pool.inuse) >= n || len(pool.idle) >= lRuntime.cfg.NumWorkers {
+            pool.Unlock()
+            return nil
+        }
+        bc := poolStateBC(pool)
+        pool.Unlock()
+        if bc == nil {
+            return fmt.Errorf("nil bytecode @poolSpawn")
+        }
+        s, err := poolNewState(pool, bc)
+        if err != nil {
+            return err
+        }
+        pool.Lock()
+        if pool.retired || len(pool.idle) >= lRuntime.cfg.NumWorkers || unsafe.SliceData(poolStateBC(pool)) != unsafe.SliceData(bc) {
+            pool.Unlock()
+            stateClose(s)
+            continue
+        }
+        if !poolUnderBudget(int64(C.lua_gc(s, C.LUA_GCCOUNT, 0)) << 10) {
+            pool.Unlock()
+            stateClose(s)
+            return nil
+        }
+        poolAdd(pool, s)
+        pool.Unlock()
+    }
+}
+
+////////////////////////////////
+func poolWarmAsync(pool *poolType) {
+    if lRuntime.cfg.MinIdle > 0 {
+        go poolSpawn(pool, lRuntime.cfg.MinIdle)
+    }
+}
+
+////////////////////////////////
+func PoolWarm(name string, n int) (error) {
+    lRuntime.Lock()
+    pool, exists := lRuntime.poolMap[name]
+    lRuntime.Unlock()
+    if !exists {
+        return fmt.Errorf("empty pool @PoolWarm")
+    }
+    return poolSpawn(pool, n)
+}
+
+////////////////////////////////
+func PoolSetRecyclePolicy(name string, policy RecyclePolicyType) (error) {
+    lRuntime.Lock()
+    defer lRuntime.Unlock()
+    pool, exists := lRuntime.poolMap[name]
+    if !exists {
+        return fmt.Errorf("empty pool @PoolSetRecyclePolicy")
+    }
+    for p := pool; p != nil; p = p.prev {
+        p.Lock()
+        p.policy = policy
+        p.Unlock()
+    }
+    return nil
+}
+
+////////////////////////////////
+func PoolGetRecycleStats(name string) (RecycleStatsType, error) {
+    lRuntime.Lock()
+    pool, exists := lRuntime.poolMap[name]
+    lRuntime.Unlock()
+    if !exists {
+        return RecycleStatsType{}, fmt.Errorf("empty pool @PoolGetRecycleStats")
+    }
+    return RecycleStatsType{
+        Cycles: pool.stats[poolRecycleCycles].Load(),
+        Memory: pool.stats[poolRecycleMemory].Load(),
+        Age: pool.stats[poolRecycleAge].Load(),
+        Error: pool.stats[poolRecycleError].Load(),
+        Leak: pool.stats[poolRecycleLeak].Load(),
+        Flush: pool.stats[poolRecycleFlush].Load(),
+        Evict: pool.stats[poolRecycleEvict].Load(),
+    }, nil
+}
+
+////////////////////////////////
+func poolAdd(pool *poolType, s *C.lua_State) (int64) {
+    i := poolIndex(pool)
+    pool.idle[i] = s
+    pool.cycle[i] = 0
+    pool.used[i] = time.Now().UnixNano()
+    pool.born[i] = pool.used[i]
+    pool.mem[i] = int64(C.lua_gc(s, C.LUA_GCCOUNT, 0)) << 10
+    if lRuntime.cfg.Integrity {
+        pool.hash[i] = integrityHash(s)
+    }
+    lRuntime.numState.Add(1)
+    lRuntime.memState.Add(pool.mem[i])
+    return i
+}
+
+////////////////////////////////
+func poolDelete(pool *poolType, i int64) (*C.lua_State) {
+    s := pool.idle[i]
+    lRuntime.numState.Add(-1)
+    lRuntime.memState.Add(-pool.mem[i])
+    delete(pool.idle, i)
+    delete(pool.cycle, i)
+    delete(pool.used, i)
+    delete(pool.mem, i)
+    delete(pool.hash, i)
+    delete(pool.born, i)
+    delete(pool.expire, i)
+    return s
+}
+
+////////////////////////////////
+func poolOverBudget() (bool) {
+    cfg := lRuntime.cfg
+    if cfg.MaxStates > 0 && lRuntime.numState.Load() > int64(cfg.MaxStates) {
+        return true
+    }
+    return cfg.MaxMemory > 0 && lRuntime.memState.Load() > cfg.MaxMemory
+}
+
+////////////////////////////////
+func poolUnderBudget(mem int64) (bool) {
+    cfg := lRuntime.cfg
+    if cfg.MaxStates > 0 && lRuntime.numState.Load() + 1 > int64(cfg.MaxStates) {
+        return false
+    }
+    return cfg.MaxMemory <= 0 || lRuntime.memState.Load() + mem <= cfg.MaxMemory
+}
+
+////////////////////////////////
+type poolEvictType struct {
+    pool *poolType
+    index int64
+    used int64
+}
+
+////////////////////////////////
+// The budget is soft, a call that needs a new state still creates one and idle states are evicted after it returns.
+func poolEvict() {
+    if !poolOverBudget() || !lRuntime.evict.TryLock() {
+        return
+    }
+    defer lRuntime.evict.Unlock()
+    lRuntime.Lock()
+    pools := make([]*poolType, 0, len(lRuntime.poolMap))
+    for _, head := range lRuntime.poolMap {
+        for p := head; p != nil; p = p.prev {
+            pools = append(pools, p)
+        }
+    }
+    lRuntime.Unlock()
+    list := []poolEvictType{}
+    for _, pool := range pools {
+        pool.Lock()
+        for i := range pool.idle {
+            if _, exists := pool.inuse[i]; !exists {
+                list = append(list, poolEvictType{pool: pool, index: i, used: pool.used[i]})
+            }
+        }
+        pool.Unlock()
+    }
+    sort.Slice(list, func(i, j int) (bool) {
+        return list[i].used < list[j].used
+    })
+    for _, e := range list {
+        if !poolOverBudget() {
+            break
+        }
+        var s *C.lua_State
+        e.pool.Lock()
+        _, exists := e.pool.inuse[e.index]
+        if !exists && e.pool.idle[e.index] != nil && e.pool.used[e.index] == e.used {
+            s = poolDelete(e.pool, e.index)
+            e.pool.stats[poolRecycleEvict].Add(1)
+        }
+        e.pool.Unlock()
+        if s != nil {
+            stateClose(s)
+        }
+    }
+}
+
 ////////////////////////////////
 func poolLockState(pool *poolType) (*C.lua_State, int64, error) {
     pool.Lock()
     defer pool.Unlock()
+    if pool.retired {
+        return nil, 0, poolErrRetired
+    }
     for i, s := range pool.idle {
         _, exists := pool.inuse[i]
         if !exists {
@@ -105,66 +914,244 @@ func poolLockState(pool *poolType) (*C.lua_State, int64, error) {
         }
     }
     if len(pool.idle) < lRuntime.cfg.NumWorkers {
-        if pool.bc == nil {
+        bc := poolStateBC(pool)
+        if bc == nil {
             return nil, 0, fmt.Errorf("nil bytecode @poolLockState")
         }
-        s, err := stateFromBC(pool.bc)
+        s, err := poolNewState(pool, bc)
         if err != nil {
             return nil, 0, err
         }
-        i := time.Now().UnixNano()
-        pool.idle[i] = s
+        i := poolAdd(pool, s)
         pool.inuse[i] = s
-        pool.cycle[i] = 0
         return s, i, nil
     }
     return nil, 0, fmt.Errorf("no available @poolLockState")
 }
 
 ////////////////////////////////
-func poolUnlockState(pool *poolType, index int64) {
-    var s *C.lua_State
+func poolUnlockState(pool *poolType, index int64, failed bool) {
+    pool.Lock()
+    s := pool.inuse[index]
+    pool.Unlock()
+    mem := int64(C.lua_gc(s, C.LUA_GCCOUNT, 0)) << 10
+    s = nil
     pool.Lock()
+    lRuntime.memState.Add(mem - pool.mem[index])
+    pool.mem[index] = mem
+    pool.used[index] = time.Now().UnixNano()
     delete(pool.inuse, index)
-    if pool.cycle[index] >= 100000 {
-        s = pool.idle[index]
-        delete(pool.idle, index)
-        delete(pool.cycle, index)
+    reason := poolRecycleReason(pool, index, failed)
+    if reason != poolRecycleNone {
+        s = poolDelete(pool, index)
+        pool.stats[reason].Add(1)
+    }
+    if pool.drain != nil && len(pool.inuse) == 0 {
+        close(pool.drain)
+        pool.drain = nil
     }
     pool.Unlock()
     if s != nil {
         stateClose(s)
+        poolWarmAsync(pool)
     }
+    poolEvict()
 }
 
 ////////////////////////////////
-func PoolCallFunc(name string, fn string, session *DataSessionType) (*DataResultType, error) {
-    if name == "" {
-        return nil, fmt.Errorf("empty name @PoolCallFunc")
+func poolRecycleReason(pool *poolType, index int64, failed bool) (int) {
+    reason, exists := pool.expire[index]
+    if exists {
+        return reason
+    }
+    policy := pool.policy
+    if failed && policy.OnError {
+        return poolRecycleError
+    }
+    if policy.MaxCycles > 0 && pool.cycle[index] >= policy.MaxCycles {
+        return poolRecycleCycles
+    }
+    if policy.MaxMemory > 0 && pool.mem[index] >= policy.MaxMemory {
+        return poolRecycleMemory
+    }
+    if policy.MaxAge > 0 && time.Duration(pool.used[index] - pool.born[index]) >= policy.MaxAge {
+        return poolRecycleAge
+    }
+    return poolRecycleNone
+}
+
+////////////////////////////////
+type poolLoadType struct {
+    done chan struct{}
+    pool *poolType
+    err error
+}
+
+////////////////////////////////
+func poolLoad(name string) (*poolType, error) {
+    loader := lRuntime.cfg.Loader
+    if loader == nil {
+        return nil, nil
     }
     lRuntime.Lock()
-    pool, exists := lRuntime.poolMap[name]
-    lRuntime.Unlock()
+    pool := lRuntime.poolMap[name]
+    if pool != nil {
+        lRuntime.Unlock()
+        return pool, nil
+    }
+    call, exists := lRuntime.loadMap[name]
     if !exists {
-        return nil, fmt.Errorf("empty pool @PoolCallFunc")
+        call = &poolLoadType{done: make(chan struct{})}
+        lRuntime.loadMap[name] = call
+    }
+    lRuntime.Unlock()
+    if exists {
+        <-call.done
+        return call.pool, call.err
+    }
+    data, err := loader.LoadContract(name)
+    if err == nil && BCIsContainer(data) {
+        err = PoolFromBC(name, data)
+    } else if err == nil {
+        err = PoolFromCode(name, string(data))
+    }
+    lRuntime.Lock()
+    if err == nil {
+        call.pool = lRuntime.poolMap[name]
+    } else {
+        call.err = fmt.Errorf("%s: %s @poolLoad", name, err.Error())
+    }
+    delete(lRuntime.loadMap, name)
+    lRuntime.Unlock()
+    close(call.done)
+    return call.pool, call.err
+}
+
+////////////////////////////////
+func PoolCallFunc(name string, fn string, session *DataSessionType) (*DataResultType, error) {
+    if name == "" {
+        return nil, fmt.Errorf("empty name @PoolCallFunc")
     }
     if session == nil {
         return nil, fmt.Errorf("nil session @PoolCallFunc")
     }
-    s, index, err := poolLockState(pool)
+    if fn == poolMigrateFunc {
+        return nil, fmt.Errorf("reserved callback @PoolCallFunc")
+    }
+    var pool *poolType
+    var s *C.lua_State
+    var index int64
+    err := poolErrRetired
+    for err == poolErrRetired {
+        lRuntime.Lock()
+        head := lRuntime.poolMap[name]
+        lRuntime.Unlock()
+        if head == nil {
+            head, err = poolLoad(name)
+            if err != nil {
+                return nil, err
+            }
+        }
+        if head == nil {
+            return nil, fmt.Errorf("empty pool @PoolCallFunc")
+        }
+        lRuntime.Lock()
+        pool, err = poolSelect(head, session)
+        lRuntime.Unlock()
+        if err != nil {
+            return nil, err
+        }
+        if pool.abi[fn] != nil {
+            err = abiCheckParams(pool.abi[fn], session.OpParams)
+            if err != nil {
+                return nil, err
+            }
+        }
+        s, index, err = poolLockState(pool)
+        if err == poolErrRetired {
+            poolWaitSwap(pool)
+        }
+    }
     if err != nil {
         return nil, err
     }
-    defer poolUnlockState(pool, index)
+    failed := false
+    defer func() {
+        poolUnlockState(pool, index, failed)
+    }()
+    fnAbi := pool.abi[fn]
+    pool.Lock()
+    prof := pool.profile
+    cover := pool.cover
+    dbg := pool.debug
+    pool.Unlock()
+    failed = true
     stateClean(s)
     stateApplySession(s, session)
+    if prof != nil {
+        profileStart(prof, s)
+    }
+    if cover != nil {
+        coverStart(cover, s)
+    }
+    if dbg != nil {
+        debugStart(dbg, s)
+    }
     err = stateCallFunc(s, fn, 1)
+    if prof != nil {
+        profileStop(s)
+    }
+    if cover != nil {
+        coverStop(s)
+    }
+    if dbg != nil {
+        debugStop(s)
+    }
+    log := stateGetLog(s)
+    poolLog(name, fn, session, log)
+    errLeak := poolCheckIntegrity(pool, index, s)
     if err != nil {
         return nil, err
     }
+    if errLeak != nil {
+        return nil, errLeak
+    }
     result, err := stateGetResult(s)
     if err != nil {
         return nil, err
     }
+    result.Log = log
+    if fnAbi != nil {
+        err = abiCheckResult(fnAbi, result)
+        if err != nil {
+            return nil, err
+        }
+    }
+    failed = false
     return result, nil
 }
+
+////////////////////////////////
+func poolCheckIntegrity(pool *poolType, index int64, s *C.lua_State) (error) {
+    pool.Lock()
+    hash, exists := pool.hash[index]
+    pool.Unlock()
+    if !exists || integrityHash(s) == hash {
+        return nil
+    }
+    pool.Lock()
+    pool.expire[index] = poolRecycleLeak
+    pool.Unlock()
+    return fmt.Errorf("state leaked @PoolCallFunc")
+}
+
+////////////////////////////////
+func poolLog(name string, fn string, session *DataSessionType, log string) {
+    logger := lRuntime.cfg.Logger
+    if logger == nil || log == "" {
+        return
+    }
+    for _, line := range strings.Split(strings.TrimSuffix(log, "\n"), "\n") {
+        logger.Info(line, "pool", name, "fn", fn, "tx", session.Tx["txid"])
+    }
+}
@@ -0,0 +1,564 @@
+////////////////////////////////
+package lyncs
+
+import (
+    "fmt"
+    "strconv"
+    "strings"
+    "sync"
+    "sync/atomic"
+    "testing"
+    "time"
+)
+
+////////////////////////////////
+func testVersionCode(v int) (string) {
+    return `function init() return {} end
+function run()
+  return {exData = {v = "` + strconv.Itoa(v) + `"}}
+end
+`
+}
+
+////////////////////////////////
+func testCallVersion(t *testing.T, name string, height string) (string) {
+    t.Helper()
+    session := &DataSessionType{}
+    if height != "" {
+        session.Block = map[string]string{"height": height}
+    }
+    r, err := PoolCallFunc(name, "run", session)
+    if err != nil {
+        t.Fatal(err)
+    }
+    return r.ExData["v"]
+}
+
+////////////////////////////////
+func TestPoolUpgradeDrain(t *testing.T) {
+    err := PoolFromCode("drain", testVersionCode(1))
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer PoolDestroy("drain")
+    lRuntime.Lock()
+    old := lRuntime.poolMap["drain"]
+    lRuntime.Unlock()
+    _, index, err := poolLockState(old)
+    if err != nil {
+        t.Fatal(err)
+    }
+    done := make(chan uint64, 1)
+    go func() {
+        v, _, err := PoolUpgradeFromCode("drain", testVersionCode(2), &DataSessionType{}, nil)
+        if err != nil {
+            t.Error(err)
+        }
+        done <- v
+    }()
+    deadline := time.Now().Add(5 * time.Second)
+    for {
+        old.Lock()
+        retired := old.retired
+        old.Unlock()
+        if retired {
+            break
+        }
+        if time.Now().After(deadline) {
+            t.Fatal("upgrade did not block the old version")
+        }
+        time.Sleep(time.Millisecond)
+    }
+    call := make(chan string, 1)
+    go func() {
+        r, err := PoolCallFunc("drain", "run", &DataSessionType{})
+        if err != nil {
+            t.Error(err)
+            call <- ""
+            return
+        }
+        call <- r.ExData["v"]
+    }()
+    select {
+    case <-done:
+        t.Fatal("upgrade returned before in-flight call finished")
+    case <-call:
+        t.Fatal("call ran while the old version was draining")
+    case <-time.After(50 * time.Millisecond):
+    }
+    if v, _ := PoolGetVersion("drain"); v != 1 {
+        t.Errorf("swapped to version %d before drain", v)
+    }
+    poolUnlockState(old, index, false)
+    select {
+    case v := <-done:
+        if v != 2 {
+            t.Errorf("upgrade returned version %d", v)
+        }
+    case <-time.After(5 * time.Second):
+        t.Fatal("upgrade did not finish after drain")
+    }
+    select {
+    case v := <-call:
+        if v != "2" {
+            t.Errorf("blocked call served by version %s", v)
+        }
+    case <-time.After(5 * time.Second):
+        t.Fatal("blocked call did not resume")
+    }
+    old.Lock()
+    idle := len(old.idle)
+    old.Unlock()
+    if idle != 0 {
+        t.Errorf("retired pool kept %d states", idle)
+    }
+}
+
+////////////////////////////////
+func TestPoolUpgradeMigrate(t *testing.T) {
+    err := PoolFromCode("migrate", testVersionCode(1))
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer PoolDestroy("migrate")
+    code := testVersionCode(2) + `function migrate(from, to)
+  return {state = {from = tostring(from), to = tostring(to)}, keyRules = {from = "w", to = "w"}}
+end
+`
+    fail := fmt.Errorf("apply failed")
+    v, _, err := PoolUpgradeFromCode("migrate", code, &DataSessionType{}, func(r *DataResultType) (error) {
+        return fail
+    })
+    if err != fail || v != 0 {
+        t.Fatalf("expected apply error, got %d %v", v, err)
+    }
+    if v := testCallVersion(t, "migrate", ""); v != "1" {
+        t.Fatalf("aborted upgrade left version %s", v)
+    }
+    var applied *DataResultType
+    v, result, err := PoolUpgradeFromCode("migrate", code, &DataSessionType{}, func(r *DataResultType) (error) {
+        applied = r
+        if v, _ := PoolGetVersion("migrate"); v != 1 {
+            t.Errorf("swapped before apply, version %d", v)
+        }
+        return nil
+    })
+    if err != nil {
+        t.Fatal(err)
+    }
+    if v != 2 || applied != result || result.StateTree["from"] != "1" || result.StateTree["to"] != "2" {
+        t.Errorf("version %d result %+v", v, result)
+    }
+    _, err = PoolCallFunc("migrate", poolMigrateFunc, &DataSessionType{})
+    if err == nil || !strings.Contains(err.Error(), "reserved callback") {
+        t.Errorf("direct migrate call returned %v", err)
+    }
+}
+
+////////////////////////////////
+func TestPoolUpgradeHeight(t *testing.T) {
+    err := PoolFromCode("uheight", testVersionCode(1))
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer PoolDestroy("uheight")
+    _, _, err = PoolUpgradeFromCode("uheight", testVersionCode(2), &DataSessionType{Block: map[string]string{"height": "100"}}, nil)
+    if err != nil {
+        t.Fatal(err)
+    }
+    _, _, err = PoolUpgradeFromCode("uheight", testVersionCode(3), &DataSessionType{Block: map[string]string{"height": "50"}}, nil)
+    if err == nil {
+        t.Error("upgrade below head height accepted")
+    }
+    _, _, err = PoolUpgradeFromCode("uheight", testVersionCode(4), nil, nil)
+    if err != nil {
+        t.Fatal(err)
+    }
+    cases := []struct {
+        height string
+        v string
+    }{{"", "4"}, {"99", "1"}, {"100", "4"}, {"1000", "4"}}
+    for _, c := range cases {
+        if v := testCallVersion(t, "uheight", c.height); v != c.v {
+            t.Errorf("height %q: version %s, expected %s", c.height, v, c.v)
+        }
+    }
+}
+
+////////////////////////////////
+func TestPoolSelectHeight(t *testing.T) {
+    err := PoolFromCode("height", testVersionCode(1))
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer PoolDestroy("height")
+    for _, c := range []struct {
+        height uint64
+        v int
+    }{{100, 2}, {50, 3}, {200, 4}} {
+        _, err = PoolAddVersionFromCode("height", c.height, testVersionCode(c.v))
+        if err != nil {
+            t.Fatal(err)
+        }
+    }
+    _, err = PoolAddVersionFromCode("height", 50, testVersionCode(5))
+    if err == nil {
+        t.Error("duplicate height accepted")
+    }
+    cases := []struct {
+        height string
+        v string
+    }{
+        {"", "4"},
+        {"0", "1"},
+        {"49", "1"},
+        {"50", "3"},
+        {"99", "3"},
+        {"100", "2"},
+        {"199", "2"},
+        {"200", "4"},
+        {"18446744073709551615", "4"},
+    }
+    for _, c := range cases {
+        if v := testCallVersion(t, "height", c.height); v != c.v {
+            t.Errorf("height %q: version %s, expected %s", c.height, v, c.v)
+        }
+    }
+    _, err = PoolCallFunc("height", "run", &DataSessionType{Block: map[string]string{"height": "x"}})
+    if err == nil {
+        t.Error("invalid height accepted")
+    }
+}
+
+////////////////////////////////
+func TestPoolProfileVersions(t *testing.T) {
+    err := PoolFromCode("profv", testVersionCode(1))
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer PoolDestroy("profv")
+    _, err = PoolAddVersionFromCode("profv", 100, testVersionCode(2))
+    if err != nil {
+        t.Fatal(err)
+    }
+    err = PoolProfileStart("profv", 1)
+    if err != nil {
+        t.Fatal(err)
+    }
+    chain := poolChain("profv")
+    for i := 0; i < 10; i ++ {
+        testCallVersion(t, "profv", "10")
+    }
+    prof := chain[1].profile
+    if prof == nil || prof != chain[0].profile {
+        t.Fatal("older version not profiled")
+    }
+    prof.Lock()
+    samples := len(prof.sampleMap)
+    prof.Unlock()
+    if samples == 0 {
+        t.Error("no samples from older version")
+    }
+    _, err = PoolProfileStop("profv")
+    if err != nil {
+        t.Fatal(err)
+    }
+    for _, p := range chain {
+        if p.profile != nil {
+            t.Error("profile left on version")
+        }
+    }
+}
+
+////////////////////////////////
+func TestPoolWarm(t *testing.T) {
+    err := PoolFromCode("warm", testVersionCode(1))
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer PoolDestroy("warm")
+    lRuntime.Lock()
+    pool := lRuntime.poolMap["warm"]
+    lRuntime.Unlock()
+    _, index, err := poolLockState(pool)
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer poolUnlockState(pool, index, false)
+    free := func() (int, int) {
+        pool.Lock()
+        defer pool.Unlock()
+        return len(pool.idle) - len(pool.inuse), len(pool.idle)
+    }
+    cfg := lRuntime.cfg
+    defer func() {
+        lRuntime.cfg = cfg
+    }()
+    limited := *cfg
+    limited.MaxStates = int(lRuntime.numState.Load()) + 3
+    lRuntime.cfg = &limited
+    err = PoolWarm("warm", 5)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if n, total := free(); n != 3 || total != 4 {
+        t.Errorf("budget capped warm: %d free %d total", n, total)
+    }
+    stats, _ := PoolGetRecycleStats("warm")
+    if stats.Evict != 0 {
+        t.Errorf("warm evicted %d states", stats.Evict)
+    }
+    lRuntime.cfg = cfg
+    err = PoolWarm("warm", 4)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if n, total := free(); n != 4 || total != 5 {
+        t.Errorf("warm counted in-use states: %d free %d total", n, total)
+    }
+}
+
+////////////////////////////////
+type testLoaderType struct {
+    count atomic.Int32
+    started chan struct{}
+    release chan struct{}
+    err error
+}
+
+////////////////////////////////
+func (loader *testLoaderType) LoadContract(name string) ([]byte, error) {
+    if loader.count.Add(1) == 1 {
+        close(loader.started)
+    }
+    <-loader.release
+    if loader.err != nil {
+        return nil, loader.err
+    }
+    return []byte(testVersionCode(1)), nil
+}
+
+////////////////////////////////
+func testLoadParallel(loader *testLoaderType, name string, n int) ([]error) {
+    cfg := lRuntime.cfg
+    defer func() {
+        lRuntime.cfg = cfg
+    }()
+    withLoader := *cfg
+    withLoader.Loader = loader
+    lRuntime.cfg = &withLoader
+    list := make([]error, n)
+    wg := sync.WaitGroup{}
+    for i := 0; i < n; i ++ {
+        wg.Add(1)
+        go func(i int) {
+            defer wg.Done()
+            r, err := PoolCallFunc(name, "run", &DataSessionType{})
+            if err == nil && r.ExData["v"] != "1" {
+                err = fmt.Errorf("version %s", r.ExData["v"])
+            }
+            list[i] = err
+        }(i)
+    }
+    <-loader.started
+    time.Sleep(50 * time.Millisecond)
+    close(loader.release)
+    wg.Wait()
+    return list
+}
+
+////////////////////////////////
+func TestPoolLoadOnce(t *testing.T) {
+    defer PoolDestroy("load")
+    loader := &testLoaderType{started: make(chan struct{}), release: make(chan struct{})}
+    for i, err := range testLoadParallel(loader, "load", 16) {
+        if err != nil {
+            t.Errorf("call %d: %v", i, err)
+        }
+    }
+    if n := loader.count.Load(); n != 1 {
+        t.Errorf("loader ran %d times", n)
+    }
+    loader = &testLoaderType{started: make(chan struct{}), release: make(chan struct{}), err: fmt.Errorf("missing")}
+    for i, err := range testLoadParallel(loader, "loadfail", 16) {
+        if err == nil {
+            t.Errorf("call %d: loader error not shared", i)
+        }
+    }
+    if n := loader.count.Load(); n != 1 {
+        t.Errorf("failing loader ran %d times", n)
+    }
+}
+
+////////////////////////////////
+const testRecycleCode = `function init() return {} end
+function run()
+  local mode = session.opParams.mode
+  if mode == "fail" then
+    error("fail")
+  end
+  if mode == "alloc" then
+    local t = {}
+    for i = 1, 20000 do t[i] = "s" .. i end
+  end
+  return {}
+end
+`
+
+////////////////////////////////
+func TestPoolRecycleStats(t *testing.T) {
+    call := func(name string, mode string) {
+        PoolCallFunc(name, "run", &DataSessionType{OpParams: map[string]string{"mode": mode}})
+    }
+    cases := []struct {
+        name string
+        policy RecyclePolicyType
+        run func(name string)
+        expect RecycleStatsType
+    }{
+        {"cycles", RecyclePolicyType{MaxCycles: 3}, func(name string) {
+            for i := 0; i < 7; i ++ {
+                call(name, "")
+            }
+        }, RecycleStatsType{Cycles: 2}},
+        {"error", RecyclePolicyType{OnError: true}, func(name string) {
+            call(name, "")
+            call(name, "fail")
+            call(name, "")
+        }, RecycleStatsType{Error: 1}},
+        {"memory", RecyclePolicyType{MaxMemory: 400 << 10}, func(name string) {
+            call(name, "")
+            call(name, "alloc")
+            call(name, "")
+        }, RecycleStatsType{Memory: 1}},
+        {"age", RecyclePolicyType{MaxAge: 50 * time.Millisecond}, func(name string) {
+            call(name, "")
+            time.Sleep(60 * time.Millisecond)
+            call(name, "")
+            call(name, "")
+        }, RecycleStatsType{Age: 1}},
+        {"flush", RecyclePolicyType{}, func(name string) {
+            call(name, "")
+            PoolUpgradeFromCode(name, testRecycleCode, nil, nil)
+            call(name, "")
+        }, RecycleStatsType{Flush: 1}},
+    }
+    for _, c := range cases {
+        name := "recycle-" + c.name
+        err := PoolFromCode(name, testRecycleCode)
+        if err != nil {
+            t.Fatal(err)
+        }
+        err = PoolSetRecyclePolicy(name, c.policy)
+        if err != nil {
+            t.Fatal(err)
+        }
+        c.run(name)
+        stats, err := PoolGetRecycleStats(name)
+        PoolDestroy(name)
+        if err != nil {
+            t.Fatal(err)
+        }
+        if stats != c.expect {
+            t.Errorf("%s: stats %+v, expected %+v", c.name, stats, c.expect)
+        }
+    }
+}
+
+////////////////////////////////
+func TestConfigRecycle(t *testing.T) {
+    cfg := lRuntime.cfg
+    defer func() {
+        lRuntime.cfg = cfg
+    }()
+    unset := *cfg
+    unset.Recycle = nil
+    Config(&unset)
+    if lRuntime.cfg.Recycle.MaxCycles != poolMaxCycle {
+        t.Errorf("unset recycle: max cycles %d", lRuntime.cfg.Recycle.MaxCycles)
+    }
+    disabled := *cfg
+    disabled.Recycle = &RecyclePolicyType{}
+    Config(&disabled)
+    err := PoolFromCode("recycle-off", testRecycleCode)
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer PoolDestroy("recycle-off")
+    lRuntime.Lock()
+    pool := lRuntime.poolMap["recycle-off"]
+    lRuntime.Unlock()
+    _, index, err := poolLockState(pool)
+    if err != nil {
+        t.Fatal(err)
+    }
+    pool.Lock()
+    pool.cycle[index] = poolMaxCycle * 2
+    pool.Unlock()
+    poolUnlockState(pool, index, false)
+    stats, _ := PoolGetRecycleStats("recycle-off")
+    if stats.Cycles != 0 {
+        t.Errorf("max cycles 0 recycled %d states", stats.Cycles)
+    }
+}
+
+////////////////////////////////
+func TestPoolEvictLRU(t *testing.T) {
+    cases := []struct {
+        name string
+        limit func(cfg *ConfigType)
+    }{
+        {"states", func(cfg *ConfigType) {
+            cfg.MaxStates = int(lRuntime.numState.Load())
+        }},
+        {"memory", func(cfg *ConfigType) {
+            cfg.MaxMemory = lRuntime.memState.Load()
+        }},
+    }
+    cfg := lRuntime.cfg
+    defer func() {
+        lRuntime.cfg = cfg
+    }()
+    for _, c := range cases {
+        lRuntime.cfg = cfg
+        err := PoolFromCode("lru-a", testVersionCode(1))
+        if err != nil {
+            t.Fatal(err)
+        }
+        err = PoolFromCode("lru-b", testVersionCode(1))
+        if err != nil {
+            t.Fatal(err)
+        }
+        testCallVersion(t, "lru-a", "")
+        lRuntime.Lock()
+        a := lRuntime.poolMap["lru-a"]
+        b := lRuntime.poolMap["lru-b"]
+        lRuntime.Unlock()
+        limited := *cfg
+        c.limit(&limited)
+        lRuntime.cfg = &limited
+        _, i1, err := poolLockState(a)
+        if err != nil {
+            t.Fatal(err)
+        }
+        _, i2, err := poolLockState(a)
+        if err != nil {
+            t.Fatal(err)
+        }
+        poolUnlockState(a, i2, false)
+        poolUnlockState(a, i1, false)
+        a.Lock()
+        countA := len(a.idle)
+        a.Unlock()
+        b.Lock()
+        countB := len(b.idle)
+        b.Unlock()
+        statsA, _ := PoolGetRecycleStats("lru-a")
+        statsB, _ := PoolGetRecycleStats("lru-b")
+        if countA != 2 || countB != 0 || statsA.Evict != 0 || statsB.Evict != 1 {
+            t.Errorf("%s: a %d states %d evicted, b %d states %d evicted", c.name, countA, statsA.Evict, countB, statsB.Evict)
+        }
+        PoolDestroy("lru-a")
+        PoolDestroy("lru-b")
+    }
+}
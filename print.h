@@ -0,0 +1,58 @@
+#include <string.h>
+
+////////////////////////////////
+#define LYNCS_LOG "lyncs.log"
+#define LYNCS_LOG_CAP "lyncs.logcap"
+
+////////////////////////////////
+static int luaL_logPrint(lua_State *s) {
+	int i, n = lua_gettop(s);
+	size_t size = 0;
+	lua_Integer cap;
+	lua_getfield(s, LUA_REGISTRYINDEX, LYNCS_LOG_CAP);
+	cap = lua_tointeger(s, -1);
+	lua_getfield(s, LUA_REGISTRYINDEX, LYNCS_LOG);
+	if (lua_type(s, -1)==LUA_TSTRING) {
+		lua_tolstring(s, -1, &size);
+	} else {
+		lua_settop(s, n+1);
+		lua_pushliteral(s, "");
+	}
+	if ((lua_Integer)size>=cap) {
+		return 0;
+	}
+	for (i=1; i<=n; i++) {
+		if (i>1) {
+			lua_pushliteral(s, "\t");
+			lua_concat(s, 2);
+		}
+		switch (lua_type(s, i)) {
+		case LUA_TSTRING:
+		case LUA_TNUMBER:
+			lua_pushvalue(s, i);
+			break;
+		case LUA_TBOOLEAN:
+			lua_pushstring(s, lua_toboolean(s, i) ? "true" : "false");
+			break;
+		default:
+			lua_pushstring(s, lua_typename(s, lua_type(s, i)));
+		}
+		lua_concat(s, 2);
+	}
+	lua_pushliteral(s, "\n");
+	lua_concat(s, 2);
+	if ((lua_Integer)lua_objlen(s, -1)>cap) {
+		const char *log = lua_tolstring(s, -1, &size);
+		lua_pushlstring(s, log, (size_t)cap);
+	}
+	lua_setfield(s, LUA_REGISTRYINDEX, LYNCS_LOG);
+	return 0;
+}
+
+////////////////////////////////
+static void luaL_openLog(lua_State *s, lua_Integer cap) {
+	lua_pushinteger(s, cap);
+	lua_setfield(s, LUA_REGISTRYINDEX, LYNCS_LOG_CAP);
+	lua_pushcfunction(s, luaL_logPrint);
+	lua_setfield(s, LUA_GLOBALSINDEX, "print");
+}
@@ -0,0 +1,259 @@
+////////////////////////////////
+package lyncs
+
+/*
+#include "hook.h"
+*/
+import "C"
+import (
+    "bytes"
+    "compress/gzip"
+    "encoding/binary"
+    "fmt"
+    "sort"
+    "strconv"
+    "strings"
+    "sync"
+    "time"
+    "unsafe"
+)
+
+////////////////////////////////
+type profileFuncKeyType struct {
+    source string
+    name string
+    line int
+}
+
+////////////////////////////////
+type profileFuncType struct {
+    name string
+    file string
+    line int
+}
+
+////////////////////////////////
+type profileSampleType struct {
+    loc []uint64
+    count int64
+    ins int64
+    nanos int64
+}
+
+////////////////////////////////
+type profileType struct {
+    sync.Mutex
+    period int
+    start time.Time
+    funcMap map[profileFuncKeyType]uint64
+    funcList []profileFuncType
+    locMap map[[2]uint64]uint64
+    locList [][2]uint64
+    sampleMap map[string]*profileSampleType
+}
+
+////////////////////////////////
+type profileActiveType struct {
+    prof *profileType
+    last *profileSampleType
+    lastTime int64
+}
+
+////////////////////////////////
+var profileStateMap sync.Map
+
+////////////////////////////////
+func profileNew(period int) (*profileType) {
+    return &profileType{
+        period: period,
+        start: time.Now(),
+        funcMap: make(map[profileFuncKeyType]uint64),
+        locMap: make(map[[2]uint64]uint64),
+        sampleMap: make(map[string]*profileSampleType),
+    }
+}
+
+////////////////////////////////
+func profileStart(prof *profileType, s *C.lua_State) {
+    profileStateMap.Store(uintptr(unsafe.Pointer(s)), &profileActiveType{prof: prof})
+    C.luaL_profileStart(s, C.int(prof.period))
+}
+
+////////////////////////////////
+func profileStop(s *C.lua_State) {
+    C.luaL_hookStop(s)
+    v, exists := profileStateMap.LoadAndDelete(uintptr(unsafe.Pointer(s)))
+    if !exists {
+        return
+    }
+    active := v.(*profileActiveType)
+    if active.last != nil {
+        active.prof.Lock()
+        active.last.nanos += time.Now().UnixNano() - active.lastTime
+        active.prof.Unlock()
+    }
+}
+
+////////////////////////////////
+//export profileRecord
+func profileRecord(s *C.lua_State, frames *C.profileFrame, n C.int) {
+    now := time.Now().UnixNano()
+    v, exists := profileStateMap.Load(uintptr(unsafe.Pointer(s)))
+    if !exists {
+        return
+    }
+    active := v.(*profileActiveType)
+    prof := active.prof
+    list := unsafe.Slice(frames, int(n))
+    prof.Lock()
+    defer prof.Unlock()
+    loc := make([]uint64, 0, len(list))
+    for i := range list {
+        loc = append(loc, profileLocation(prof, &list[i]))
+    }
+    key := make([]byte, 0, len(loc) * 4)
+    for _, id := range loc {
+        key = binary.AppendUvarint(key, id)
+    }
+    sample := prof.sampleMap[string(key)]
+    if sample == nil {
+        sample = &profileSampleType{loc: loc}
+        prof.sampleMap[string(key)] = sample
+    }
+    sample.count ++
+    sample.ins += int64(prof.period)
+    if active.last != nil {
+        active.last.nanos += now - active.lastTime
+    }
+    active.last = sample
+    active.lastTime = now
+}
+
+////////////////////////////////
+func profileLocation(prof *profileType, frame *C.profileFrame) (uint64) {
+    key := profileFuncKeyType{line: int(frame.linedefined)}
+    if frame.source != nil {
+        key.source = C.GoString(frame.source)
+    }
+    if frame.name != nil {
+        key.name = C.GoString(frame.name)
+    }
+    fid, exists := prof.funcMap[key]
+    if !exists {
+        f := profileFuncType{line: key.line, file: strings.TrimLeft(key.source, "=@")}
+        if frame.name != nil {
+            f.name = key.name
+        } else if key.line == 0 {
+            f.name = "main"
+        } else {
+            f.name = "function@" + strconv.Itoa(key.line)
+        }
+        prof.funcList = append(prof.funcList, f)
+        fid = uint64(len(prof.funcList))
+        prof.funcMap[key] = fid
+    }
+    keyLoc := [2]uint64{fid, uint64(max(int(frame.currentline), 0))}
+    lid, exists := prof.locMap[keyLoc]
+    if !exists {
+        prof.locList = append(prof.locList, keyLoc)
+        lid = uint64(len(prof.locList))
+        prof.locMap[keyLoc] = lid
+    }
+    return lid
+}
+
+////////////////////////////////
+func profileEncode(prof *profileType) ([]byte, error) {
+    prof.Lock()
+    defer prof.Unlock()
+    strMap := map[string]int64{"": 0}
+    strList := []string{""}
+    str := func(v string) (int64) {
+        i, exists := strMap[v]
+        if !exists {
+            i = int64(len(strList))
+            strMap[v] = i
+            strList = append(strList, v)
+        }
+        return i
+    }
+    w := &bytes.Buffer{}
+    for _, t := range [][2]string{{"samples", "count"}, {"instructions", "count"}, {"time", "nanoseconds"}} {
+        profileWriteBytes(w, 1, profileMessage(1, str(t[0]), 2, str(t[1])))
+    }
+    keys := make([]string, 0, len(prof.sampleMap))
+    for k := range prof.sampleMap {
+        keys = append(keys, k)
+    }
+    sort.Strings(keys)
+    for _, k := range keys {
+        sample := prof.sampleMap[k]
+        m := &bytes.Buffer{}
+        loc := make([]int64, 0, len(sample.loc))
+        for _, id := range sample.loc {
+            loc = append(loc, int64(id))
+        }
+        profileWritePacked(m, 1, loc)
+        profileWritePacked(m, 2, []int64{sample.count, sample.ins, sample.nanos})
+        profileWriteBytes(w, 2, m.Bytes())
+    }
+    for i, loc := range prof.locList {
+        line := profileMessage(1, int64(loc[0]), 2, int64(loc[1]))
+        m := &bytes.Buffer{}
+        profileWriteVarint(m, 1, uint64(i+1))
+        profileWriteBytes(m, 4, line)
+        profileWriteBytes(w, 4, m.Bytes())
+    }
+    for i, f := range prof.funcList {
+        name := str(f.name)
+        profileWriteBytes(w, 5, profileMessage(1, int64(i+1), 2, name, 3, name, 4, str(f.file), 5, int64(f.line)))
+    }
+    for _, v := range strList {
+        profileWriteBytes(w, 6, []byte(v))
+    }
+    profileWriteVarint(w, 9, uint64(prof.start.UnixNano()))
+    profileWriteVarint(w, 10, uint64(time.Since(prof.start).Nanoseconds()))
+    profileWriteBytes(w, 11, profileMessage(1, str("instructions"), 2, str("count")))
+    profileWriteVarint(w, 12, uint64(prof.period))
+    data := &bytes.Buffer{}
+    gz := gzip.NewWriter(data)
+    _, err := gz.Write(w.Bytes())
+    if err == nil {
+        err = gz.Close()
+    }
+    if err != nil {
+        return nil, fmt.Errorf("%s @profileEncode", err.Error())
+    }
+    return data.Bytes(), nil
+}
+
+////////////////////////////////
+func profileMessage(kv ...int64) ([]byte) {
+    m := &bytes.Buffer{}
+    for i := 0; i + 1 < len(kv); i += 2 {
+        profileWriteVarint(m, int(kv[i]), uint64(kv[i+1]))
+    }
+    return m.Bytes()
+}
+
+////////////////////////////////
+func profileWriteVarint(w *bytes.Buffer, field int, v uint64) {
+    w.Write(binary.AppendUvarint(nil, uint64(field) << 3))
+    w.Write(binary.AppendUvarint(nil, v))
+}
+
+////////////////////////////////
+func profileWriteBytes(w *bytes.Buffer, field int, v []byte) {
+    w.Write(binary.AppendUvarint(nil, uint64(field) << 3 | 2))
+    w.Write(binary.AppendUvarint(nil, uint64(len(v))))
+    w.Write(v)
+}
+
+////////////////////////////////
+func profileWritePacked(w *bytes.Buffer, field int, v []int64) {
+    data := make([]byte, 0, len(v) * 2)
+    for _, n := range v {
+        data = binary.AppendUvarint(data, uint64(n))
+    }
+    profileWriteBytes(w, field, data)
+}
@@ -0,0 +1,51 @@
+////////////////////////////////
+package lyncs
+
+import (
+    "testing"
+)
+
+////////////////////////////////
+func TestProfileFuncKey(t *testing.T) {
+    code := `
+local function a() local x = 0 for i = 1, 2000 do x = x + i end return x end
+local function b() local x = 0 for i = 1, 2000 do x = x + i end return x end
+function init() return {} end
+function run() a() b() return {} end
+`
+    err := PoolFromCode("prof", code)
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer PoolDestroy("prof")
+    err = PoolProfileStart("prof", 10)
+    if err != nil {
+        t.Fatal(err)
+    }
+    for i := 0; i < 20; i ++ {
+        _, err = PoolCallFunc("prof", "run", &DataSessionType{})
+        if err != nil {
+            t.Fatal(err)
+        }
+    }
+    lRuntime.Lock()
+    prof := lRuntime.poolMap["prof"].profile
+    lRuntime.Unlock()
+    data, err := PoolProfileStop("prof")
+    if err != nil || len(data) == 0 {
+        t.Fatal(err)
+    }
+    names := map[string]int{}
+    for key, fid := range prof.funcMap {
+        f := prof.funcList[fid-1]
+        if f.name != key.name && key.name != "" || f.line != key.line {
+            t.Errorf("key %+v maps to %+v", key, f)
+        }
+        names[f.name] ++
+    }
+    for _, name := range []string{"a", "b", "function@5"} {
+        if names[name] != 1 {
+            t.Errorf("function %s: %d entries in %v", name, names[name], names)
+        }
+    }
+}
@@ -0,0 +1,180 @@
+////////////////////////////////
+package lyncs
+
+/*
+#include <stdlib.h>
+#include "lua.h"
+#include "lauxlib.h"
+*/
+import "C"
+import (
+    "fmt"
+    "runtime"
+    "sort"
+    "strconv"
+    "strings"
+    "unsafe"
+)
+
+////////////////////////////////
+type ReplType struct {
+    s *C.lua_State
+    abi AbiType
+    session *DataSessionType
+}
+
+////////////////////////////////
+var replChunkName = C.CString("=repl")
+
+////////////////////////////////
+func ReplNew(data []byte) (*ReplType, error) {
+    var s *C.lua_State
+    var err error
+    if BCIsContainer(data) {
+        c, err := BCDecode(data)
+        if err != nil {
+            return nil, err
+        }
+        err = bcCheck(c)
+        if err != nil {
+            return nil, err
+        }
+        bc, err := bcVerify(c.Bytecode)
+        if err != nil {
+            return nil, err
+        }
+        s, err = stateFromBC(bc)
+    } else if len(data) > 0 {
+        s, _, err = stateFromCode(string(data))
+    } else {
+        s, err = stateSandbox()
+    }
+    if err != nil {
+        return nil, err
+    }
+    abi, err := stateGetAbi(s)
+    if err != nil {
+        stateClose(s)
+        return nil, err
+    }
+    return &ReplType{s: s, abi: abi, session: &DataSessionType{}}, nil
+}
+
+////////////////////////////////
+func ReplClose(r *ReplType) {
+    if r.s != nil {
+        stateClose(r.s)
+        r.s = nil
+    }
+}
+
+////////////////////////////////
+func ReplSession(r *ReplType, session *DataSessionType) {
+    if session == nil {
+        session = &DataSessionType{}
+    }
+    r.session = session
+    stateClean(r.s)
+    stateApplySession(r.s, session)
+}
+
+////////////////////////////////
+func ReplEval(r *ReplType, line string) (string, error) {
+    if r.s == nil {
+        return "", fmt.Errorf("closed @ReplEval")
+    }
+    s := r.s
+    C.lua_settop(s, 0)
+    code := "return " + line
+    if C.LUA_OK != C.luaL_loadbuffer(s, (*C.char)(unsafe.Pointer(unsafe.StringData(code))), C.size_t(len(code)), replChunkName) {
+        C.lua_settop(s, 0)
+        code = line
+        if C.LUA_OK != C.luaL_loadbuffer(s, (*C.char)(unsafe.Pointer(unsafe.StringData(code))), C.size_t(len(code)), replChunkName) {
+            return "", stateError(s, "ReplEval")
+        }
+    }
+    runtime.KeepAlive(code)
+    stateEnvG(s)
+    if C.LUA_OK != C.lua_pcall(s, 0, C.LUA_MULTRET, 0) {
+        err := stateError(s, "ReplEval")
+        return "", fmt.Errorf("%s%s", stateGetLog(s), err.Error())
+    }
+    n := int(C.lua_gettop(s))
+    log := stateGetLog(s)
+    list := make([]string, 0, n)
+    for i := 1; i <= n; i ++ {
+        list = append(list, stateRepr(s, C.int(i), make(map[unsafe.Pointer]bool), 0))
+    }
+    C.lua_settop(s, 0)
+    return log + strings.Join(list, "\t"), nil
+}
+
+////////////////////////////////
+func ReplCall(r *ReplType, fn string) (*DataResultType, error) {
+    if r.s == nil {
+        return nil, fmt.Errorf("closed @ReplCall")
+    }
+    fnAbi := r.abi[fn]
+    if fnAbi != nil {
+        err := abiCheckParams(fnAbi, r.session.OpParams)
+        if err != nil {
+            return nil, err
+        }
+    }
+    stateClean(r.s)
+    stateApplySession(r.s, r.session)
+    err := stateCallFunc(r.s, fn, 1)
+    log := stateGetLog(r.s)
+    if err != nil {
+        return nil, fmt.Errorf("%s%s", log, err.Error())
+    }
+    result, err := stateGetResult(r.s)
+    if err != nil {
+        return nil, err
+    }
+    result.Log = log
+    if fnAbi != nil {
+        err = abiCheckResult(fnAbi, result)
+        if err != nil {
+            return nil, err
+        }
+    }
+    return result, nil
+}
+
+////////////////////////////////
+func stateRepr(s *C.lua_State, i C.int, seen map[unsafe.Pointer]bool, depth int) (string) {
+    if i < 0 {
+        i = C.lua_gettop(s) + 1 + i
+    }
+    switch C.lua_type(s, i) {
+    case C.LUA_TNIL:
+        return "nil"
+    case C.LUA_TBOOLEAN:
+        return strconv.FormatBool(C.lua_toboolean(s, i) != 0)
+    case C.LUA_TNUMBER:
+        return strconv.FormatFloat(float64(C.lua_tonumber(s, i)), 'g', 14, 64)
+    case C.LUA_TSTRING:
+        return strconv.Quote(stateToString(s, i))
+    case C.LUA_TTABLE:
+        p := C.lua_topointer(s, i)
+        if seen[p] || depth >= stateMaxDepth || C.lua_checkstack(s, 3) == 0 {
+            return "{...}"
+        }
+        seen[p] = true
+        defer delete(seen, p)
+        list := []string{}
+        C.lua_pushnil(s)
+        for C.lua_next(s, i) != 0 {
+            k := "[" + stateRepr(s, -2, seen, depth+1) + "]"
+            if C.lua_type(s, -2) == C.LUA_TSTRING {
+                k = stateToString(s, -2)
+            }
+            list = append(list, k + "=" + stateRepr(s, -1, seen, depth+1))
+            C.lua_settop(s, C.lua_gettop(s)-1)
+        }
+        sort.Strings(list)
+        return "{" + strings.Join(list, ", ") + "}"
+    }
+    return C.GoString(C.lua_typename(s, C.lua_type(s, i)))
+}
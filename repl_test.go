@@ -0,0 +1,77 @@
+////////////////////////////////
+package lyncs
+
+import (
+    "strings"
+    "testing"
+)
+
+////////////////////////////////
+func TestReplEval(t *testing.T) {
+    r, err := ReplNew([]byte(`abi = {run = {params = {n = "uint"}}}
+function init() return {} end
+function run() return {state = {n = session.opParams.n}, keyRules = {n = "w"}} end
+`))
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer ReplClose(r)
+    deep := "{...}"
+    for i := 0; i < stateMaxDepth; i ++ {
+        deep = "{n=" + deep + "}"
+    }
+    cases := []struct {
+        line string
+        out string
+        err string
+    }{
+        {"1 + 2", "3", ""},
+        {"'a\\n', true, nil, 0.5", `"a\n"` + "\ttrue\tnil\t0.5", ""},
+        {"{b = 1, a = {2}}", "{a={[1]=2}, b=1}", ""},
+        {"local x = 1", "", ""},
+        {"type(run)", `"function"`, ""},
+        {"(function() local t = {} t.self = t return t end)()", "{self={...}}", ""},
+        {"(function() local t = {1} return {a = t, b = t} end)()", "{a={[1]=1}, b={[1]=1}}", ""},
+        {"(function() local r = {} local t = r for i = 1, 40 do t.n = {} t = t.n end return r end)()", deep, ""},
+        {"error('boom')", "", "boom"},
+        {"1 +", "", "unexpected symbol"},
+    }
+    for _, c := range cases {
+        out, err := ReplEval(r, c.line)
+        if c.err != "" {
+            if err == nil || !strings.Contains(err.Error(), c.err) {
+                t.Errorf("%s: expected error %q, got %v", c.line, c.err, err)
+            }
+            continue
+        }
+        if err != nil || out != c.out {
+            t.Errorf("%s: got %q %v, expected %q", c.line, out, err, c.out)
+        }
+    }
+}
+
+////////////////////////////////
+func TestReplCall(t *testing.T) {
+    r, err := ReplNew([]byte(`abi = {run = {params = {n = "uint"}}}
+function init() return {} end
+function run() return {state = {n = session.opParams.n}, keyRules = {n = "w"}} end
+`))
+    if err != nil {
+        t.Fatal(err)
+    }
+    ReplSession(r, &DataSessionType{OpParams: map[string]string{"n": "5"}})
+    result, err := ReplCall(r, "run")
+    if err != nil || result.StateTree["n"] != "5" {
+        t.Errorf("call %v %v", result, err)
+    }
+    ReplSession(r, &DataSessionType{OpParams: map[string]string{"n": "x"}})
+    _, err = ReplCall(r, "run")
+    if err == nil || !strings.Contains(err.Error(), "invalid opParams:n") {
+        t.Errorf("expected invalid opParams, got %v", err)
+    }
+    ReplClose(r)
+    _, err = ReplEval(r, "1")
+    if err == nil {
+        t.Error("eval after close")
+    }
+}
@@ -9,6 +9,7 @@
 				if t~=_G_RAW then error("variable read-only "..k,2) end
 				if k=="session" or k=="state" then t[k]=v; return end
 				if fn[k] and t[k]==nil and type(v)=="function" then t[k]=v; return end
+				if k=="abi" and t[k]==nil and type(v)=="table" then t[k]=v; return end
 				error("variable read-only "..k, 2)
 			end
 		}
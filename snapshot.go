@@ -0,0 +1,373 @@
+////////////////////////////////
+package lyncs
+
+/*
+#include <stdlib.h>
+#include "lua.h"
+#include "lauxlib.h"
+#include "bytecode.h"
+#include "snapshot.h"
+*/
+import "C"
+import (
+    "encoding/binary"
+    "fmt"
+    "math"
+    "runtime"
+    "sort"
+    "strings"
+    "unsafe"
+)
+
+////////////////////////////////
+const (
+    snapshotNil = C.SNAPSHOT_NIL
+    snapshotBool = C.SNAPSHOT_BOOL
+    snapshotNumber = C.SNAPSHOT_NUMBER
+    snapshotString = C.SNAPSHOT_STRING
+    snapshotObject = C.SNAPSHOT_OBJECT
+    snapshotBase = C.SNAPSHOT_BASE
+)
+
+////////////////////////////////
+type snapshotValueType struct {
+    kind int
+    num float64
+    str string
+    ref int
+    path []string
+}
+
+////////////////////////////////
+type snapshotUpvalueType struct {
+    join bool
+    ref int
+    n int
+    value snapshotValueType
+}
+
+////////////////////////////////
+type snapshotObjectType struct {
+    bc []byte
+    narr int
+    nrec int
+    keys []snapshotValueType
+    values []snapshotValueType
+    upvalues []snapshotUpvalueType
+}
+
+////////////////////////////////
+type snapshotGlobalType struct {
+    key string
+    value snapshotValueType
+}
+
+////////////////////////////////
+type snapshotType struct {
+    objects []snapshotObjectType
+    globals []snapshotGlobalType
+}
+
+////////////////////////////////
+type snapshotWalkType struct {
+    snap *snapshotType
+    base map[unsafe.Pointer]string
+    env unsafe.Pointer
+    objects map[unsafe.Pointer]int
+    upvalues map[unsafe.Pointer][2]int
+}
+
+////////////////////////////////
+func snapshotNew(bc []byte) ([]byte, error) {
+    if len(bc) == 0 {
+        return nil, fmt.Errorf("nil bytecode @snapshotNew")
+    }
+    s, err := stateSandbox()
+    if err != nil {
+        return nil, err
+    }
+    defer stateClose(s)
+    w := &snapshotWalkType{
+        snap: &snapshotType{},
+        base: make(map[unsafe.Pointer]string),
+        objects: make(map[unsafe.Pointer]int),
+        upvalues: make(map[unsafe.Pointer][2]int),
+    }
+    keys := make(map[string]bool)
+    C.lua_pushnil(s)
+    for C.lua_next(s, C.LUA_GLOBALSINDEX) != 0 {
+        if C.lua_type(s, -2) == C.LUA_TSTRING {
+            k := stateToString(s, -2)
+            keys[k] = true
+            snapshotScanBase(s, w.base, k)
+        }
+        C.lua_settop(s, -2)
+    }
+    stateGetField(s, C.LUA_GLOBALSINDEX, "_G")
+    w.env = C.lua_topointer(s, -1)
+    C.lua_settop(s, -2)
+    if C.LUA_OK != C.luaL_loadbuffer(s, (*C.char)(unsafe.Pointer(&bc[0])), C.size_t(len(bc)), stateChunkName) {
+        return nil, fmt.Errorf("load failed @snapshotNew")
+    }
+    runtime.KeepAlive(bc)
+    stateEnvG(s)
+    err = stateCall(s, 0)
+    if err != nil {
+        return nil, err
+    }
+    list := []string{}
+    C.lua_pushnil(s)
+    for C.lua_next(s, C.LUA_GLOBALSINDEX) != 0 {
+        if C.lua_type(s, -2) != C.LUA_TSTRING {
+            C.lua_settop(s, -3)
+            return nil, fmt.Errorf("non-string global @snapshotNew")
+        }
+        k := stateToString(s, -2)
+        if !keys[k] && k != "session" && k != "state" {
+            list = append(list, k)
+        }
+        C.lua_settop(s, -2)
+    }
+    sort.Strings(list)
+    for _, k := range list {
+        stateGetField(s, C.LUA_GLOBALSINDEX, k)
+        v, err := snapshotValue(w, s, C.lua_gettop(s))
+        C.lua_settop(s, -2)
+        if err != nil {
+            return nil, err
+        }
+        w.snap.globals = append(w.snap.globals, snapshotGlobalType{key: k, value: v})
+    }
+    return snapshotEncode(w.snap), nil
+}
+
+////////////////////////////////
+func snapshotScanBase(s *C.lua_State, base map[unsafe.Pointer]string, k string) {
+    t := C.lua_gettop(s)
+    switch C.lua_type(s, t) {
+    case C.LUA_TNIL, C.LUA_TBOOLEAN, C.LUA_TNUMBER, C.LUA_TSTRING:
+        return
+    }
+    p := C.lua_topointer(s, t)
+    if _, exists := base[p]; !exists {
+        base[p] = k
+    }
+    if C.lua_type(s, t) != C.LUA_TTABLE {
+        return
+    }
+    snapshotScanFields(s, base, k, t)
+    if C.lua_getmetatable(s, t) == 0 {
+        return
+    }
+    stateGetField(s, -1, "__index")
+    if C.lua_type(s, -1) == C.LUA_TTABLE {
+        snapshotScanFields(s, base, k, C.lua_gettop(s))
+    }
+    C.lua_settop(s, t)
+}
+
+////////////////////////////////
+func snapshotScanFields(s *C.lua_State, base map[unsafe.Pointer]string, k string, t C.int) {
+    C.lua_pushnil(s)
+    for C.lua_next(s, t) != 0 {
+        switch C.lua_type(s, -1) {
+        case C.LUA_TNIL, C.LUA_TBOOLEAN, C.LUA_TNUMBER, C.LUA_TSTRING:
+        default:
+            p := C.lua_topointer(s, -1)
+            _, exists := base[p]
+            if !exists && C.lua_type(s, -2) == C.LUA_TSTRING {
+                base[p] = k + "." + stateToString(s, -2)
+            }
+        }
+        C.lua_settop(s, -2)
+    }
+}
+
+////////////////////////////////
+func snapshotValue(w *snapshotWalkType, s *C.lua_State, i C.int) (snapshotValueType, error) {
+    switch C.lua_type(s, i) {
+    case C.LUA_TNIL:
+        return snapshotValueType{kind: snapshotNil}, nil
+    case C.LUA_TBOOLEAN:
+        return snapshotValueType{kind: snapshotBool, num: float64(C.lua_toboolean(s, i))}, nil
+    case C.LUA_TNUMBER:
+        return snapshotValueType{kind: snapshotNumber, num: float64(C.lua_tonumber(s, i))}, nil
+    case C.LUA_TSTRING:
+        return snapshotValueType{kind: snapshotString, str: stateToString(s, i)}, nil
+    }
+    p := C.lua_topointer(s, i)
+    if path, exists := w.base[p]; exists {
+        return snapshotValueType{kind: snapshotBase, path: strings.Split(path, ".")}, nil
+    }
+    if ref, exists := w.objects[p]; exists {
+        return snapshotValueType{kind: snapshotObject, ref: ref}, nil
+    }
+    if C.lua_type(s, i) == C.LUA_TTABLE {
+        return snapshotTable(w, s, i, p)
+    }
+    if C.lua_type(s, i) == C.LUA_TFUNCTION && C.lua_iscfunction(s, i) == 0 {
+        return snapshotFunc(w, s, i, p)
+    }
+    return snapshotValueType{}, fmt.Errorf("unsupported %s @snapshotValue", C.GoString(C.lua_typename(s, C.lua_type(s, i))))
+}
+
+////////////////////////////////
+func snapshotTable(w *snapshotWalkType, s *C.lua_State, i C.int, p unsafe.Pointer) (snapshotValueType, error) {
+    ref := len(w.snap.objects)
+    w.objects[p] = ref
+    w.snap.objects = append(w.snap.objects, snapshotObjectType{})
+    if C.lua_getmetatable(s, i) != 0 {
+        C.lua_settop(s, -2)
+        return snapshotValueType{}, fmt.Errorf("metatable @snapshotTable")
+    }
+    var narr, nrec C.uint32_t
+    if C.snapshotLayout(s, i, &narr, &nrec) == 0 {
+        return snapshotValueType{}, fmt.Errorf("table layout @snapshotTable")
+    }
+    obj := snapshotObjectType{narr: int(narr), nrec: int(nrec)}
+    C.lua_pushnil(s)
+    for C.lua_next(s, i) != 0 {
+        t := C.lua_gettop(s)
+        k, err := snapshotValue(w, s, t-1)
+        if err == nil {
+            var v snapshotValueType
+            v, err = snapshotValue(w, s, t)
+            obj.keys = append(obj.keys, k)
+            obj.values = append(obj.values, v)
+        }
+        C.lua_settop(s, t-1)
+        if err != nil {
+            C.lua_settop(s, t-2)
+            return snapshotValueType{}, err
+        }
+    }
+    w.snap.objects[ref] = obj
+    return snapshotValueType{kind: snapshotObject, ref: ref}, nil
+}
+
+////////////////////////////////
+func snapshotFunc(w *snapshotWalkType, s *C.lua_State, i C.int, p unsafe.Pointer) (snapshotValueType, error) {
+    ref := len(w.snap.objects)
+    w.objects[p] = ref
+    w.snap.objects = append(w.snap.objects, snapshotObjectType{})
+    C.lua_getfenv(s, i)
+    env := C.lua_topointer(s, -1)
+    C.lua_settop(s, -2)
+    if env != w.env {
+        return snapshotValueType{}, fmt.Errorf("function env @snapshotFunc")
+    }
+    C.lua_pushvalue(s, i)
+    var buffer C.bcBuffer
+    n := C.luaL_bcDump(s, &buffer, C.uint32_t(stateDumpFlags()))
+    C.lua_settop(s, -2)
+    if n <= 0 {
+        return snapshotValueType{}, fmt.Errorf("bytecode failed @snapshotFunc")
+    }
+    obj := snapshotObjectType{bc: C.GoBytes(unsafe.Pointer(buffer.bc), C.int(n))}
+    C.free(unsafe.Pointer(buffer.bc))
+    for j := C.int(1); C.lua_getupvalue(s, i, j) != nil; j ++ {
+        id := C.lua_upvalueid(s, i, j)
+        if up, exists := w.upvalues[id]; exists {
+            C.lua_settop(s, -2)
+            obj.upvalues = append(obj.upvalues, snapshotUpvalueType{join: true, ref: up[0], n: up[1]})
+            continue
+        }
+        w.upvalues[id] = [2]int{ref, int(j)}
+        v, err := snapshotValue(w, s, C.lua_gettop(s))
+        C.lua_settop(s, -2)
+        if err != nil {
+            return snapshotValueType{}, err
+        }
+        obj.upvalues = append(obj.upvalues, snapshotUpvalueType{value: v})
+    }
+    w.snap.objects[ref] = obj
+    return snapshotValueType{kind: snapshotObject, ref: ref}, nil
+}
+
+////////////////////////////////
+func snapshotEncode(snap *snapshotType) ([]byte) {
+    data := binary.LittleEndian.AppendUint32(nil, uint32(len(snap.objects)))
+    for _, obj := range snap.objects {
+        if obj.bc == nil {
+            data = append(data, 0)
+            data = binary.LittleEndian.AppendUint32(data, uint32(obj.narr))
+            data = binary.LittleEndian.AppendUint32(data, uint32(obj.nrec))
+            continue
+        }
+        data = append(data, 1)
+        data = snapshotAppendBytes(data, obj.bc)
+    }
+    for _, obj := range snap.objects {
+        if obj.bc == nil {
+            data = binary.LittleEndian.AppendUint32(data, uint32(len(obj.keys)))
+            for j := range obj.keys {
+                data = snapshotAppendValue(data, &obj.keys[j])
+                data = snapshotAppendValue(data, &obj.values[j])
+            }
+            continue
+        }
+        data = binary.LittleEndian.AppendUint32(data, uint32(len(obj.upvalues)))
+        for _, up := range obj.upvalues {
+            if up.join {
+                data = append(data, 1)
+                data = binary.LittleEndian.AppendUint32(data, uint32(up.ref))
+                data = binary.LittleEndian.AppendUint32(data, uint32(up.n))
+                continue
+            }
+            data = append(data, 0)
+            data = snapshotAppendValue(data, &up.value)
+        }
+    }
+    data = binary.LittleEndian.AppendUint32(data, uint32(len(snap.globals)))
+    for _, g := range snap.globals {
+        data = snapshotAppendBytes(data, []byte(g.key))
+        data = snapshotAppendValue(data, &g.value)
+    }
+    return data
+}
+
+////////////////////////////////
+func snapshotAppendBytes(data []byte, b []byte) ([]byte) {
+    data = binary.LittleEndian.AppendUint32(data, uint32(len(b)))
+    return append(data, b...)
+}
+
+////////////////////////////////
+func snapshotAppendValue(data []byte, v *snapshotValueType) ([]byte) {
+    data = append(data, byte(v.kind))
+    switch v.kind {
+    case snapshotBool:
+        data = append(data, byte(v.num))
+    case snapshotNumber:
+        data = binary.LittleEndian.AppendUint64(data, math.Float64bits(v.num))
+    case snapshotString:
+        data = snapshotAppendBytes(data, []byte(v.str))
+    case snapshotObject:
+        data = binary.LittleEndian.AppendUint32(data, uint32(v.ref))
+    case snapshotBase:
+        data = binary.LittleEndian.AppendUint32(data, uint32(len(v.path)))
+        for _, k := range v.path {
+            data = snapshotAppendBytes(data, []byte(k))
+        }
+    }
+    return data
+}
+
+////////////////////////////////
+func snapshotRestore(snap []byte) (*C.lua_State, error) {
+    if len(snap) == 0 {
+        return nil, fmt.Errorf("empty snapshot @snapshotRestore")
+    }
+    s, err := stateSandbox()
+    if err != nil {
+        return nil, err
+    }
+    r := C.luaL_snapshotRestore(s, (*C.char)(unsafe.Pointer(&snap[0])), C.size_t(len(snap)), stateChunkName)
+    runtime.KeepAlive(snap)
+    if r != C.LUA_OK {
+        err = stateError(s, "snapshotRestore")
+        stateClose(s)
+        return nil, err
+    }
+    return s, nil
+}
@@ -0,0 +1,283 @@
+#include <string.h>
+#include <stdint.h>
+
+////////////////////////////////
+#define SNAPSHOT_NIL 0
+#define SNAPSHOT_BOOL 1
+#define SNAPSHOT_NUMBER 2
+#define SNAPSHOT_STRING 3
+#define SNAPSHOT_OBJECT 4
+#define SNAPSHOT_BASE 5
+
+////////////////////////////////
+typedef struct {
+	const char *data;
+	size_t n;
+	const char *chunk;
+} snapshotData;
+
+////////////////////////////////
+typedef struct {
+	const char *p;
+	const char *end;
+} snapshotReader;
+
+////////////////////////////////
+static int snapshotU8(snapshotReader *r, uint8_t *v) {
+	if (r->p>=r->end) {
+		return 0;
+	}
+	*v = (uint8_t)*r->p++;
+	return 1;
+}
+
+////////////////////////////////
+static int snapshotU32(snapshotReader *r, uint32_t *v) {
+	if (r->end-r->p<4) {
+		return 0;
+	}
+	memcpy(v, r->p, 4);
+	r->p += 4;
+	return 1;
+}
+
+////////////////////////////////
+static int snapshotBytes(snapshotReader *r, const char **b, uint32_t *n) {
+	if (!snapshotU32(r, n) || (size_t)(r->end-r->p)<*n) {
+		return 0;
+	}
+	*b = r->p;
+	r->p += *n;
+	return 1;
+}
+
+////////////////////////////////
+static int snapshotLayoutMatch(lua_State *s, int keys, int n, uint32_t narr, uint32_t nrec) {
+	int c, j, ok = 1;
+	lua_createtable(s, (int)narr, (int)nrec);
+	c = lua_gettop(s);
+	for (j=1; j<=n; j++) {
+		lua_rawgeti(s, keys, j);
+		lua_pushboolean(s, 1);
+		lua_rawset(s, c);
+	}
+	j = 0;
+	lua_pushnil(s);
+	while (lua_next(s, c)!=0) {
+		lua_settop(s, -2);
+		lua_rawgeti(s, keys, ++j);
+		ok = lua_rawequal(s, -1, -2);
+		lua_settop(s, -2);
+		if (!ok) {
+			break;
+		}
+	}
+	lua_settop(s, c-1);
+	return ok && j==n;
+}
+
+////////////////////////////////
+static int snapshotLayout(lua_State *s, int t, uint32_t *narr, uint32_t *nrec) {
+	int keys, n = 0, run = 1, i, ncand = 0;
+	uint32_t lead = 0, a, h, hmax = 1, cand[40];
+	lua_Number d;
+	if (!lua_checkstack(s, 8)) {
+		return 0;
+	}
+	lua_newtable(s);
+	keys = lua_gettop(s);
+	lua_pushnil(s);
+	while (lua_next(s, t)!=0) {
+		lua_settop(s, -2);
+		if (run && lua_type(s, -1)==LUA_TNUMBER) {
+			d = lua_tonumber(s, -1);
+			run = d>lead && d<=(1<<26) && d==(lua_Number)(uint32_t)d;
+			if (run) {
+				lead = (uint32_t)d;
+			}
+		} else {
+			run = 0;
+		}
+		lua_pushvalue(s, -1);
+		lua_rawseti(s, keys, ++n);
+	}
+	cand[ncand++] = 0;
+	if (lead>0) {
+		cand[ncand++] = lead;
+	}
+	for (a=1; a<=2*lead && ncand<40; a<<=1) {
+		if (a!=lead) {
+			cand[ncand++] = a;
+		}
+	}
+	while (hmax<(uint32_t)n) {
+		hmax <<= 1;
+	}
+	hmax <<= 6;
+	for (i=0; i<ncand; i++) {
+		for (h=0; h<=hmax; h=h ? h<<1 : 1) {
+			if (snapshotLayoutMatch(s, keys, n, cand[i], h)) {
+				*narr = cand[i];
+				*nrec = h;
+				lua_settop(s, keys-1);
+				return 1;
+			}
+		}
+	}
+	lua_settop(s, keys-1);
+	return 0;
+}
+
+////////////////////////////////
+static int snapshotPush(lua_State *s, snapshotReader *r, int objs) {
+	uint8_t kind, b;
+	uint32_t n, i, ref;
+	const char *str;
+	double d;
+	if (!snapshotU8(r, &kind)) {
+		return 0;
+	}
+	switch (kind) {
+	case SNAPSHOT_NIL:
+		lua_pushnil(s);
+		return 1;
+	case SNAPSHOT_BOOL:
+		if (!snapshotU8(r, &b)) {
+			return 0;
+		}
+		lua_pushboolean(s, b);
+		return 1;
+	case SNAPSHOT_NUMBER:
+		if (r->end-r->p<8) {
+			return 0;
+		}
+		memcpy(&d, r->p, 8);
+		r->p += 8;
+		lua_pushnumber(s, d);
+		return 1;
+	case SNAPSHOT_STRING:
+		if (!snapshotBytes(r, &str, &n)) {
+			return 0;
+		}
+		lua_pushlstring(s, str, n);
+		return 1;
+	case SNAPSHOT_OBJECT:
+		if (!snapshotU32(r, &ref)) {
+			return 0;
+		}
+		lua_rawgeti(s, objs, ref+1);
+		return 1;
+	case SNAPSHOT_BASE:
+		if (!snapshotU32(r, &n) || n==0) {
+			return 0;
+		}
+		lua_pushvalue(s, LUA_GLOBALSINDEX);
+		for (i=0; i<n; i++) {
+			if (!snapshotBytes(r, &str, &ref)) {
+				return 0;
+			}
+			lua_pushlstring(s, str, ref);
+			lua_gettable(s, -2);
+			lua_remove(s, -2);
+		}
+		return 1;
+	}
+	return 0;
+}
+
+////////////////////////////////
+static int luaL_snapshotRestoreF(lua_State *s) {
+	snapshotData *d = (snapshotData*)lua_touserdata(s, 1);
+	snapshotReader r;
+	uint32_t nobj, narr, nrec, n, i, j, ref, k;
+	uint8_t kind;
+	const char *b;
+	int objs, env, t;
+	r.p = d->data;
+	r.end = d->data+d->n;
+	lua_settop(s, 0);
+	if (!snapshotU32(&r, &nobj)) {
+		return luaL_error(s, "invalid snapshot");
+	}
+	lua_createtable(s, nobj, 0);
+	objs = lua_gettop(s);
+	lua_getfield(s, LUA_GLOBALSINDEX, "_G");
+	env = lua_gettop(s);
+	for (i=0; i<nobj; i++) {
+		if (!snapshotU8(&r, &kind)) {
+			return luaL_error(s, "invalid snapshot");
+		}
+		if (kind==0) {
+			if (!snapshotU32(&r, &narr) || !snapshotU32(&r, &nrec)) {
+				return luaL_error(s, "invalid snapshot");
+			}
+			lua_createtable(s, narr, nrec);
+		} else {
+			if (!snapshotBytes(&r, &b, &n) || luaL_loadbuffer(s, b, n, d->chunk)!=0) {
+				return luaL_error(s, "invalid snapshot");
+			}
+			lua_pushvalue(s, env);
+			lua_setfenv(s, -2);
+		}
+		lua_rawseti(s, objs, i+1);
+	}
+	for (i=0; i<nobj; i++) {
+		lua_rawgeti(s, objs, i+1);
+		t = lua_gettop(s);
+		if (!snapshotU32(&r, &n)) {
+			return luaL_error(s, "invalid snapshot");
+		}
+		if (lua_istable(s, t)) {
+			for (j=0; j<n; j++) {
+				if (!snapshotPush(s, &r, objs) || !snapshotPush(s, &r, objs)) {
+					return luaL_error(s, "invalid snapshot");
+				}
+				lua_rawset(s, t);
+			}
+		} else {
+			for (j=1; j<=n; j++) {
+				if (!snapshotU8(&r, &kind)) {
+					return luaL_error(s, "invalid snapshot");
+				}
+				if (kind) {
+					if (!snapshotU32(&r, &ref) || !snapshotU32(&r, &k)) {
+						return luaL_error(s, "invalid snapshot");
+					}
+					lua_rawgeti(s, objs, ref+1);
+					lua_upvaluejoin(s, t, j, -1, k);
+				} else {
+					if (!snapshotPush(s, &r, objs)) {
+						return luaL_error(s, "invalid snapshot");
+					}
+					lua_setupvalue(s, t, j);
+				}
+				lua_settop(s, t);
+			}
+		}
+		lua_settop(s, t-1);
+	}
+	if (!snapshotU32(&r, &n)) {
+		return luaL_error(s, "invalid snapshot");
+	}
+	for (j=0; j<n; j++) {
+		if (!snapshotBytes(&r, &b, &k)) {
+			return luaL_error(s, "invalid snapshot");
+		}
+		lua_pushlstring(s, b, k);
+		if (!snapshotPush(s, &r, objs)) {
+			return luaL_error(s, "invalid snapshot");
+		}
+		lua_rawset(s, LUA_GLOBALSINDEX);
+	}
+	lua_settop(s, 0);
+	return 0;
+}
+
+////////////////////////////////
+static int luaL_snapshotRestore(lua_State *s, const char *data, size_t n, const char *chunk) {
+	snapshotData d;
+	d.data = data;
+	d.n = n;
+	d.chunk = chunk;
+	return lua_cpcall(s, luaL_snapshotRestoreF, &d);
+}
@@ -0,0 +1,191 @@
+////////////////////////////////
+package lyncs
+
+import (
+    "reflect"
+    "strconv"
+    "strings"
+    "testing"
+)
+
+////////////////////////////////
+const testSnapshotCode = `local count = 0
+local prices = {base = 10, tiers = {2, 3, 5}}
+local function bump(n) count = count + n return count end
+local function peek() return count end
+local fmt = string.format
+function init() return {} end
+function run()
+  local n = tonumber(session.opParams.n)
+  local total = prices.base
+  for i, t in ipairs(prices.tiers) do
+    total = total + t * n
+  end
+  bump(n)
+  return {state = {total = fmt("%d:%d", total, peek())}, keyRules = {total = "w"}}
+end
+`
+
+////////////////////////////////
+func testSnapshotBC(tb testing.TB) ([]byte) {
+    tb.Helper()
+    s, bc, err := stateFromCode(testSnapshotCode)
+    if err != nil {
+        tb.Fatal(err)
+    }
+    stateClose(s)
+    return bc
+}
+
+////////////////////////////////
+func TestSnapshotRestore(t *testing.T) {
+    bc := testSnapshotBC(t)
+    snap, err := snapshotNew(bc)
+    if err != nil {
+        t.Fatal(err)
+    }
+    s1, err := stateFromBC(bc)
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer stateClose(s1)
+    s2, err := snapshotRestore(snap)
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer stateClose(s2)
+    for i := 1; i <= 4; i ++ {
+        session := &DataSessionType{OpParams: map[string]string{"n": strconv.Itoa(i)}}
+        list := []*DataResultType{}
+        s := s1
+        for j := 0; j < 2; j ++ {
+            if j == 1 {
+                s = s2
+            }
+            stateClean(s)
+            stateApplySession(s, session)
+            err = stateCallFunc(s, "run", 1)
+            if err != nil {
+                t.Fatal(err)
+            }
+            r, err := stateGetResult(s)
+            if err != nil {
+                t.Fatal(err)
+            }
+            list = append(list, r)
+        }
+        expect := strconv.Itoa(10 + 10 * i) + ":" + strconv.Itoa(i * (i + 1) / 2)
+        if list[0].StateTree["total"] != expect {
+            t.Fatalf("call %d: total %v, expected %s", i, list[0].StateTree["total"], expect)
+        }
+        if !reflect.DeepEqual(list[0], list[1]) {
+            t.Fatalf("call %d: restored %+v, expected %+v", i, list[1], list[0])
+        }
+    }
+}
+
+////////////////////////////////
+func TestSnapshotPairsOrder(t *testing.T) {
+    // String keys hash with a per-state seed, so only other key types have a reproducible order.
+    list := []string{
+        "local t = {10, 20, 30}",
+        "local t = {5, 6, [1.5] = 1, [true] = 2}",
+        "local t = {} for i = 1, 20 do t[i] = i end",
+        "local t = {} for i = 1, 20 do t[i * 3] = i end",
+        "local t = {} t[1.5] = 2 t[100] = 3 t[true] = 4 t[1] = 5 t[2] = 6 t[7] = 1 t[-3] = 2 t[false] = 0 for i = 10, 30, 2 do t[i] = i end",
+        "local t = {} for i = 1, 9 do t[i * 64] = i end t[128] = nil t[3.25] = 1",
+    }
+    for _, body := range list {
+        code := body + `
+function init() return {} end
+function run()
+  local o = ""
+  for k in pairs(t) do o = o .. tostring(k) .. "," end
+  return {exData = {o = o}}
+end
+`
+        s, bc, err := stateFromCode(code)
+        if err != nil {
+            t.Fatal(err)
+        }
+        stateClose(s)
+        snap, err := snapshotNew(bc)
+        if err != nil {
+            t.Fatalf("%s: %v", body, err)
+        }
+        order := [2]string{}
+        for j := range order {
+            if j == 0 {
+                s, err = stateFromBC(bc)
+            } else {
+                s, err = snapshotRestore(snap)
+            }
+            if err != nil {
+                t.Fatal(err)
+            }
+            stateClean(s)
+            stateApplySession(s, &DataSessionType{})
+            err = stateCallFunc(s, "run", 1)
+            if err != nil {
+                t.Fatal(err)
+            }
+            r, err := stateGetResult(s)
+            stateClose(s)
+            if err != nil {
+                t.Fatal(err)
+            }
+            order[j] = r.ExData["o"]
+        }
+        if order[0] != order[1] {
+            t.Errorf("%s: restored order %s, expected %s", body, order[1], order[0])
+        }
+    }
+    code := `local t = {}
+local x = 7
+for i = 1, 300 do
+  x = (x * 1103 + 12345) % 65536
+  local k = x % 7 == 0 and (x / 8) or (x % 500)
+  if x % 5 == 0 then t[k] = nil else t[k] = i end
+end
+function init() return {} end
+function run() return {exData = {n = tostring(t[1])}} end
+`
+    s, bc, err := stateFromCode(code)
+    if err != nil {
+        t.Fatal(err)
+    }
+    stateClose(s)
+    _, err = snapshotNew(bc)
+    if err == nil || !strings.Contains(err.Error(), "table layout") {
+        t.Errorf("unreproducible layout returned %v", err)
+    }
+}
+
+////////////////////////////////
+func BenchmarkStateFromBC(b *testing.B) {
+    bc := testSnapshotBC(b)
+    b.ResetTimer()
+    for i := 0; i < b.N; i ++ {
+        s, err := stateFromBC(bc)
+        if err != nil {
+            b.Fatal(err)
+        }
+        stateClose(s)
+    }
+}
+
+////////////////////////////////
+func BenchmarkSnapshotRestore(b *testing.B) {
+    snap, err := snapshotNew(testSnapshotBC(b))
+    if err != nil {
+        b.Fatal(err)
+    }
+    b.ResetTimer()
+    for i := 0; i < b.N; i ++ {
+        s, err := snapshotRestore(snap)
+        if err != nil {
+            b.Fatal(err)
+        }
+        stateClose(s)
+    }
+}
@@ -8,13 +8,18 @@ package lyncs
 #include "lualib.h"
 #include "lauxlib.h"
 #include "bytecode.h"
+#include "print.h"
 */
 import "C"
 import (
     "fmt"
+    "sync"
     "unsafe"
+    "sort"
     "strings"
     "runtime"
+    "slices"
+    "crypto/sha256"
     _ "embed"
 )
 
@@ -32,30 +37,25 @@ var stateRemoveMap = map[string][]string{
     "_G": {"jit","collectgarbage","rawget","rawset","rawequal","loadfile","load","loadstring","dofile","gcinfo","coroutine","debug","getfenv","setfenv","pcall","xpcall","newproxy","getmetatable"},
 }
 
+////////////////////////////////
+const stateMaxDepth = 32
+
+////////////////////////////////
+const stateChunk = "lyncs"
+const stateLogKey = "lyncs.log"
+var stateChunkName = C.CString("=" + stateChunk)
+
 ////////////////////////////////
 func stateFromCode(code string) (*C.lua_State, []byte, error) {
-    lenCode := len(code)
-    if lenCode == 0 {
-        return nil, nil, fmt.Errorf("empty code @stateFromCode")
-    }
     s, err := stateSandbox()
     if err != nil {
         return nil, nil, err
     }
-    r := C.luaL_loadbuffer(s, (*C.char)(unsafe.Pointer(unsafe.StringData(code))), C.size_t(lenCode), nil)
-    runtime.KeepAlive(code)
-    if r != C.LUA_OK {
-        err = stateError(s, "stateFromCode")
+    bc, err := stateDump(s, code, stateDumpFlags(), "stateFromCode")
+    if err != nil {
         stateClose(s)
         return nil, nil, err
     }
-    var bc []byte
-    var buffer C.bcBuffer
-    n := C.luaL_bcDump(s, &buffer)
-    if n > 0 {
-        bc = C.GoBytes(unsafe.Pointer(buffer.bc), C.int(n))
-        C.free(unsafe.Pointer(buffer.bc))
-    }
     stateEnvG(s)
     err = stateCall(s, 0)
     if err != nil {
@@ -66,6 +66,45 @@ func stateFromCode(code string) (*C.lua_State, []byte, error) {
     return s, bc, nil
 }
 
+////////////////////////////////
+func stateDump(s *C.lua_State, code string, flags uint32, caller string) ([]byte, error) {
+    lenCode := len(code)
+    if lenCode == 0 {
+        return nil, fmt.Errorf("empty code @%s", caller)
+    }
+    r := C.luaL_loadbuffer(s, (*C.char)(unsafe.Pointer(unsafe.StringData(code))), C.size_t(lenCode), stateChunkName)
+    runtime.KeepAlive(code)
+    if r != C.LUA_OK {
+        return nil, stateError(s, caller)
+    }
+    var buffer C.bcBuffer
+    n := C.luaL_bcDump(s, &buffer, C.uint32_t(flags))
+    if n <= 0 {
+        return nil, fmt.Errorf("bytecode failed @%s", caller)
+    }
+    bc := C.GoBytes(unsafe.Pointer(buffer.bc), C.int(n))
+    C.free(unsafe.Pointer(buffer.bc))
+    return bc, nil
+}
+
+////////////////////////////////
+func stateDumpFlags() (uint32) {
+    if lRuntime.cfg.Deterministic {
+        return bcDumpFlagStrip | bcDumpFlagDeterministic
+    }
+    return bcDumpFlagStrip
+}
+
+////////////////////////////////
+func stateDumpCode(code string, flags uint32) ([]byte, error) {
+    s, err := stateSandbox()
+    if err != nil {
+        return nil, err
+    }
+    defer stateClose(s)
+    return stateDump(s, code, flags, "stateDumpCode")
+}
+
 ////////////////////////////////
 func stateFromBC(bc []byte) (*C.lua_State, error) {
     lenBC := len(bc)
@@ -76,7 +115,7 @@ func stateFromBC(bc []byte) (*C.lua_State, error) {
     if err != nil {
         return nil, err
     }
-    r := C.luaL_loadbuffer(s, (*C.char)(unsafe.Pointer(&bc[0])), C.size_t(lenBC), nil)
+    r := C.luaL_loadbuffer(s, (*C.char)(unsafe.Pointer(&bc[0])), C.size_t(lenBC), stateChunkName)
     runtime.KeepAlive(bc)
     if r != C.LUA_OK {
         stateClose(s)
@@ -110,6 +149,9 @@ func stateSandbox() (*C.lua_State, error) {
     C.luaopen_jit(s)
     C.lua_settop(s, 0)
     C.lua_gc(s, C.LUA_GCSTOP, 0)
+    if lRuntime.cfg.Debug {
+        C.luaL_openLog(s, C.lua_Integer(lRuntime.cfg.MaxLogSize))
+    }
     for k, v := range stateRemoveMap {
         err = stateSetGlobalTableFieldNil(s, k, v)
         if err != nil {
@@ -130,6 +172,9 @@ func stateSandbox() (*C.lua_State, error) {
                 codeCallbacks += `["`+v+`"]=true,`
             }
         }
+        if !slices.Contains(lRuntime.cfg.Callbacks, poolMigrateFunc) {
+            codeCallbacks += `["`+poolMigrateFunc+`"]=true,`
+        }
         codeCallbacks += "}"
         codeDebug := "\tprint = function(...) end"
         if lRuntime.cfg.Debug {
@@ -137,9 +182,14 @@ func stateSandbox() (*C.lua_State, error) {
         }
         codeSandbox := ""
         stateReadonlyList := ""
-        for t, fn := range lRuntime.cfg.Builtin {
+        builtinList := make([]string, 0, len(lRuntime.cfg.Builtin))
+        for t := range lRuntime.cfg.Builtin {
+            builtinList = append(builtinList, t)
+        }
+        sort.Strings(builtinList)
+        for _, t := range builtinList {
             stateReadonlyList += "\t" + t + " = _set("+ t +")\r\n"
-            codeSandbox += fn + "\r\n"
+            codeSandbox += lRuntime.cfg.Builtin[t] + "\r\n"
         }
         codeSandbox += luaSandbox
         codeSandbox = strings.Replace(codeSandbox, "--[[-code-callbacks-]]", codeCallbacks, 1)
@@ -153,7 +203,7 @@ func stateSandbox() (*C.lua_State, error) {
             return nil, err
         }
         var buffer C.bcBuffer
-        n := C.luaL_bcDump(s, &buffer)
+        n := C.luaL_bcDump(s, &buffer, bcDumpFlagStrip | bcDumpFlagDeterministic)
         if n > 0 {
             bcSandbox = C.GoBytes(unsafe.Pointer(buffer.bc), C.int(n))
             C.free(unsafe.Pointer(buffer.bc))
@@ -170,6 +220,36 @@ func stateSandbox() (*C.lua_State, error) {
     return s, nil
 }
 
+////////////////////////////////
+var stateJitVersion = sync.OnceValue(func() (string) {
+    s := C.luaL_newstate()
+    if s == nil {
+        return ""
+    }
+    defer stateClose(s)
+    C.luaopen_jit(s)
+    cJit := C.CString("jit")
+    defer C.free(unsafe.Pointer(cJit))
+    C.lua_getfield(s, C.LUA_GLOBALSINDEX, cJit)
+    cKey := C.CString("version")
+    defer C.free(unsafe.Pointer(cKey))
+    C.lua_getfield(s, -1, cKey)
+    return stateToString(s, -1)
+})
+
+////////////////////////////////
+func stateSandboxHash() ([]byte, error) {
+    if len(bcSandbox) == 0 {
+        s, err := stateSandbox()
+        if err != nil {
+            return nil, err
+        }
+        stateClose(s)
+    }
+    hash := sha256.Sum256(bcSandbox)
+    return hash[:], nil
+}
+
 ////////////////////////////////
 func stateEnvG(s *C.lua_State) {
     cEnv := C.CString("_G")
@@ -194,6 +274,20 @@ func stateCallFunc(s *C.lua_State, fn string, nResult C.int) (error) {
     return stateCall(s, nResult)
 }
 
+////////////////////////////////
+func stateCallFuncNumber(s *C.lua_State, fn string, args []float64, nResult C.int) (error) {
+    cFunc := C.CString(fn)
+    defer C.free(unsafe.Pointer(cFunc))
+    C.lua_getfield(s, C.LUA_GLOBALSINDEX, cFunc)
+    for _, v := range args {
+        C.lua_pushnumber(s, C.lua_Number(v))
+    }
+    if C.LUA_OK != C.lua_pcall(s, C.int(len(args)), nResult, 0) {
+        return stateError(s, "stateCallFuncNumber")
+    }
+    return nil
+}
+
 ////////////////////////////////
 func stateCheckFunc(s *C.lua_State, fn string) (bool) {
     cFunc := C.CString(fn)
@@ -206,18 +300,83 @@ func stateCheckFunc(s *C.lua_State, fn string) (bool) {
     return true
 }
 
+////////////////////////////////
+func stateGetAbi(s *C.lua_State) (AbiType, error) {
+    cKey := C.CString("abi")
+    defer C.free(unsafe.Pointer(cKey))
+    C.lua_getfield(s, C.LUA_GLOBALSINDEX, cKey)
+    defer C.lua_settop(s, C.lua_gettop(s)-1)
+    if C.lua_type(s, -1) == C.LUA_TNIL {
+        return nil, nil
+    }
+    if C.lua_type(s, -1) != C.LUA_TTABLE {
+        return nil, fmt.Errorf("not a table @stateGetAbi")
+    }
+    var err error
+    abi := make(AbiType, 8)
+    stateGetTableData(s, func() {
+        if err != nil {
+            return
+        }
+        if C.lua_type(s, -2) != C.LUA_TSTRING || C.lua_type(s, -1) != C.LUA_TTABLE {
+            err = fmt.Errorf("invalid abi @stateGetAbi")
+            return
+        }
+        fnAbi := &AbiFuncType{}
+        stateGetTableData(s, func() {
+            if C.lua_type(s, -2) != C.LUA_TSTRING {
+                return
+            }
+            key := stateToString(s, -2)
+            if key == "params" && C.lua_type(s, -1) == C.LUA_TTABLE {
+                fnAbi.Params = make(map[string]string, 8)
+                stateGetTableData(s, func() {
+                    if C.lua_type(s, -2) != C.LUA_TSTRING || C.lua_type(s, -1) != C.LUA_TSTRING {
+                        err = fmt.Errorf("invalid params type @stateGetAbi")
+                        return
+                    }
+                    stateGetDataToMap(s, &fnAbi.Params)
+                })
+            } else if key == "returns" && C.lua_type(s, -1) == C.LUA_TTABLE {
+                fnAbi.Returns = make([]string, 0, 4)
+                stateGetTableData(s, func() {
+                    if C.lua_type(s, -2) != C.LUA_TNUMBER || C.lua_type(s, -1) != C.LUA_TSTRING {
+                        err = fmt.Errorf("invalid returns type @stateGetAbi")
+                        return
+                    }
+                    stateGetDataToList(s, &fnAbi.Returns)
+                })
+            } else if key == "params" {
+                err = fmt.Errorf("invalid params @stateGetAbi")
+            }
+        })
+        abi[stateToString(s, -2)] = fnAbi
+    })
+    if err != nil {
+        return nil, err
+    }
+    err = abiVerify(abi)
+    if err != nil {
+        return nil, err
+    }
+    return abi, nil
+}
+
 ////////////////////////////////
 func stateError(s *C.lua_State, caller string) (error) {
-    msg := C.GoString(C.lua_tolstring(s, -1, nil))
+    msg := stateToString(s, -1)
     C.lua_settop(s, C.lua_gettop(s)-1)
     msgS := strings.Split(msg, `"]:`)
+    if len(msgS) < 2 && strings.HasPrefix(msg, stateChunk + ":") {
+        msgS = []string{"", msg[len(stateChunk)+1:]}
+    }
     if len(msgS) < 2 {
-        return fmt.Errorf(msg + " @"+caller)
+        return fmt.Errorf("%s @%s", msg, caller)
     }
     if len(msgS[1]) > 40 {
         msgS[1] = msgS[1][:40] + ".."
     }
-    return fmt.Errorf(msgS[1] + " @"+caller)
+    return fmt.Errorf("%s @%s", msgS[1], caller)
 }
 
 ////////////////////////////////
@@ -228,12 +387,29 @@ func stateClose(s *C.lua_State) {
 ////////////////////////////////
 func stateClean(s *C.lua_State) {
     C.lua_settop(s, 0)
+    stateGetLog(s)
     stateSetGlobalTableFieldNil(s, "_G", []string{"session", "state"})
     if int(C.lua_gc(s,C.LUA_GCCOUNT,0)) >= 8192 {
         C.lua_gc(s, C.LUA_GCCOLLECT, 0)
     }
 }
 
+////////////////////////////////
+func stateGetLog(s *C.lua_State) (string) {
+    cKey := C.CString(stateLogKey)
+    defer C.free(unsafe.Pointer(cKey))
+    C.lua_getfield(s, C.LUA_REGISTRYINDEX, cKey)
+    if C.lua_type(s, -1) != C.LUA_TSTRING {
+        C.lua_settop(s, C.lua_gettop(s)-1)
+        return ""
+    }
+    log := stateToString(s, -1)
+    C.lua_settop(s, C.lua_gettop(s)-1)
+    C.lua_pushnil(s)
+    C.lua_setfield(s, C.LUA_REGISTRYINDEX, cKey)
+    return log
+}
+
 ////////////////////////////////
 func stateSetGlobalTableField(s *C.lua_State, table string, field []string, fSet func(*C.lua_State, int)) (error) {
     ct := C.CString(table)
@@ -268,27 +444,57 @@ func stateSetGlobalTableFieldString(s *C.lua_State, table string, field []string
     })
 }
 
+////////////////////////////////
+func statePushString(s *C.lua_State, v string) {
+    C.lua_pushlstring(s, (*C.char)(unsafe.Pointer(unsafe.StringData(v))), C.size_t(len(v)))
+    runtime.KeepAlive(v)
+}
+
+////////////////////////////////
+func stateToString(s *C.lua_State, i C.int) (string) {
+    var n C.size_t
+    p := C.lua_tolstring(s, i, &n)
+    if p == nil {
+        return ""
+    }
+    return C.GoStringN(p, C.int(n))
+}
+
+////////////////////////////////
+func stateSetField(s *C.lua_State, i C.int, k string) {
+    if i < 0 && i > C.LUA_REGISTRYINDEX {
+        i --
+    }
+    statePushString(s, k)
+    C.lua_insert(s, -2)
+    C.lua_settable(s, i)
+}
+
+////////////////////////////////
+func stateGetField(s *C.lua_State, i C.int, k string) {
+    if i < 0 && i > C.LUA_REGISTRYINDEX {
+        i --
+    }
+    statePushString(s, k)
+    C.lua_gettable(s, i)
+}
+
 ////////////////////////////////
 func stateSetTableByMap1(s *C.lua_State, m map[string]string, i int, k string) {
-    var cKey *C.char
     lenKey := len(m)
     if lenKey <= 0 {
         return
     }
     C.lua_createtable(s, 0, C.int(lenKey))
     for k2, v := range m {
-        C.lua_pushlstring(s, (*C.char)(unsafe.Pointer(unsafe.StringData(v))), C.size_t(len(v)))
-        runtime.KeepAlive(v)
-        cKey = C.CString(k2)
-        C.lua_setfield(s, -2, cKey)
-        C.free(unsafe.Pointer(cKey))
+        statePushString(s, k2)
+        statePushString(s, v)
+        C.lua_settable(s, -3)
     }
     if i > 0 {
         C.lua_rawseti(s, -2, C.int(i))
     } else {
-        cKey = C.CString(k)
-        C.lua_setfield(s, -2, cKey);
-        C.free(unsafe.Pointer(cKey))
+        stateSetField(s, -2, k)
     }
 }
 
@@ -302,15 +508,28 @@ func stateSetTableByMapList(s *C.lua_State, l []map[string]string, k string) {
     for i := 0; i < lenKey; i ++ {
         stateSetTableByMap1(s, l[i], i+1, "")
     }
-    cKey := C.CString(k)
-    C.lua_setfield(s, -2, cKey);
-    C.free(unsafe.Pointer(cKey))
+    stateSetField(s, -2, k)
 }
 
 ////////////////////////////////
-func stateSetTableByMap2(s *C.lua_State, m map[string]map[string]string) {
+func statePushState(s *C.lua_State, m DataStateType, depth int) {
+    C.lua_createtable(s, 0, C.int(len(m)))
     for k, v := range m {
-        stateSetTableByMap1(s, v, 0, k)
+        switch t := v.(type) {
+        case string:
+            statePushString(s, k)
+            statePushString(s, t)
+            C.lua_settable(s, -3)
+        case map[string]string:
+            stateSetTableByMap1(s, t, 0, k)
+        default:
+            t2 := dataStateTable(v)
+            if len(t2) <= 0 || depth >= stateMaxDepth {
+                continue
+            }
+            statePushState(s, t2, depth+1)
+            stateSetField(s, -2, k)
+        }
     }
 }
 
@@ -325,28 +544,28 @@ func stateApplySession(s *C.lua_State, session *DataSessionType) {
     stateSetTableByMap1(s, session.ExData, 0, "exData")
     stateSetTableByMapList(s, session.TxInputs, "txInputs")
     stateSetTableByMapList(s, session.TxOutputs, "txOutputs")
-    cKey := C.CString("session")
-    C.lua_setfield(s, C.LUA_GLOBALSINDEX, cKey);
-    C.free(unsafe.Pointer(cKey))
+    stateSetField(s, C.LUA_GLOBALSINDEX, "session")
     // state
-    C.lua_createtable(s, 0, C.int(len(session.State)))
-    stateSetTableByMap2(s, session.State)
-    cKey = C.CString("state")
-    C.lua_setfield(s, C.LUA_GLOBALSINDEX, cKey);
-    C.free(unsafe.Pointer(cKey))
+    statePushState(s, session.StateTree, 1)
+    for k, v := range session.State {
+        if _, exists := session.StateTree[k]; !exists {
+            stateSetTableByMap1(s, v, 0, k)
+        }
+    }
+    stateSetField(s, C.LUA_GLOBALSINDEX, "state")
 }
 
 ////////////////////////////////
 func stateGetDataToMap(s *C.lua_State, r *map[string]string) {
     if C.lua_type(s, -2) == C.LUA_TSTRING && C.lua_type(s, -1) == C.LUA_TSTRING {
-        (*r)[C.GoString(C.lua_tolstring(s, -2, nil))] = C.GoString(C.lua_tolstring(s, -1, nil))
+        (*r)[stateToString(s, -2)] = stateToString(s, -1)
     }
 }
 
 ////////////////////////////////
 func stateGetDataToList(s *C.lua_State, r *[]string) {
     if C.lua_type(s, -2) == C.LUA_TNUMBER && C.lua_type(s, -1) == C.LUA_TSTRING {
-        *r = append(*r, C.GoString(C.lua_tolstring(s, -1, nil)))
+        *r = append(*r, stateToString(s, -1))
     }
 }
 
@@ -372,22 +591,34 @@ func stateGetTableMap1(s *C.lua_State, size int) (map[string]string) {
 }
 
 ////////////////////////////////
-func stateGetTableMap2(s *C.lua_State, size1 int, size2 int) (map[string]map[string]string) {
+func stateGetTableState(s *C.lua_State, size int, depth int) (DataStateType, error) {
     if C.lua_type(s, -1) != C.LUA_TTABLE {
-        return nil
+        return nil, nil
     }
-    result := make(map[string]map[string]string, size1)
+    if depth > stateMaxDepth {
+        return nil, fmt.Errorf("state too deep @stateGetTableState")
+    }
+    var err error
+    result := make(DataStateType, size)
     stateGetTableData(s, func() {
-        if C.lua_type(s, -2) == C.LUA_TSTRING && C.lua_type(s, -1) == C.LUA_TTABLE {
-            key := C.GoString(C.lua_tolstring(s, -2, nil))
-            data := make(map[string]string, size2)
-            stateGetTableData(s, func() {
-                stateGetDataToMap(s, &data)
-            })
-            result[key] = data
+        if err != nil || C.lua_type(s, -2) != C.LUA_TSTRING {
+            return
+        }
+        switch C.lua_type(s, -1) {
+        case C.LUA_TSTRING:
+            result[stateToString(s, -2)] = stateToString(s, -1)
+        case C.LUA_TTABLE:
+            var data DataStateType
+            data, err = stateGetTableState(s, 8, depth+1)
+            if data != nil {
+                result[stateToString(s, -2)] = data
+            }
         }
     })
-    return result
+    if err != nil {
+        return nil, err
+    }
+    return result, nil
 }
 
 ////////////////////////////////
@@ -410,19 +641,21 @@ func stateGetTableMapList(s *C.lua_State, size1 int, size2 int) ([]map[string]st
 
 ////////////////////////////////
 func stateGetResult(s *C.lua_State) (*DataResultType, error) {
+    top := C.lua_gettop(s)
+    defer C.lua_settop(s, top-1)
     if C.lua_type(s, -1) != C.LUA_TTABLE {
         return nil, fmt.Errorf("not a table @stateGetResult")
     }
-    defer C.lua_settop(s, C.lua_gettop(s)-1)
     result := &DataResultType{}
     key := ""
+    var err error
     C.lua_pushnil(s)
     for C.lua_next(s, -2) != 0 {
         if C.lua_type(s, -2) != C.LUA_TSTRING {
             C.lua_settop(s, C.lua_gettop(s)-1)
             continue
         }
-        key = C.GoString(C.lua_tolstring(s, -2, nil))
+        key = stateToString(s, -2)
         if key == "op" {
             result.Op = stateGetTableMap1(s, 8)
         } else if key == "opParams" {
@@ -434,7 +667,11 @@ func stateGetResult(s *C.lua_State) (*DataResultType, error) {
         } else if key == "keyRules" {
             result.KeyRules = stateGetTableMap1(s, 16)
         } else if key == "state" {
-            result.State = stateGetTableMap2(s, 16, 8)
+            result.StateTree, err = stateGetTableState(s, 16, 1)
+            if err != nil {
+                return nil, err
+            }
+            result.State = dataStateMap2(result.StateTree)
         }
         C.lua_settop(s, C.lua_gettop(s)-1)
     }
@@ -0,0 +1,10 @@
+local base = 10
+function init() return {} end
+function run()
+  local n = tonumber(session.opParams.n)
+  local total = base
+  for i = 1, n do
+    total = total + i
+  end
+  return {state = {total = tostring(total)}, keyRules = {total = "w"}}
+end
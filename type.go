@@ -5,7 +5,10 @@ package lyncs
 //#include "lua.h"
 import "C"
 import (
+    "log/slog"
     "sync"
+    "sync/atomic"
+    "time"
 )
 
 ////////////////////////////////
@@ -14,26 +17,101 @@ type ConfigType struct {
     Callbacks []string
     Builtin map[string]string
     MaxInSlot int
+    KeySep string
+    HeightKey string
+    Deterministic bool
     Debug bool
+    MaxLogSize int
+    Logger *slog.Logger
+    CacheDir string
+    MinIdle int
+    MaxStates int
+    MaxMemory int64
+    Loader ContractLoader
+    Snapshot bool
+    Integrity bool
+    Recycle *RecyclePolicyType
 }
 
+////////////////////////////////
+type RecyclePolicyType struct {
+    MaxCycles int
+    MaxMemory int64
+    MaxAge time.Duration
+    OnError bool
+}
+
+////////////////////////////////
+type RecycleStatsType struct {
+    Cycles uint64
+    Memory uint64
+    Age uint64
+    Error uint64
+    Leak uint64
+    Flush uint64
+    Evict uint64
+}
+
+////////////////////////////////
+type ContractLoader interface {
+    LoadContract(name string) ([]byte, error)
+}
+
+////////////////////////////////
+type AbiFuncType struct {
+    Params map[string]string
+    Returns []string
+}
+
+////////////////////////////////
+type AbiType map[string]*AbiFuncType
+
 ////////////////////////////////
 type poolType struct {
     sync.Mutex
     idle map[int64]*C.lua_State
     inuse map[int64]*C.lua_State
     cycle map[int64]int
+    used map[int64]int64
+    mem map[int64]int64
+    hash map[int64]uint64
+    born map[int64]int64
+    expire map[int64]int
     code string
     bc []byte
+    bcDebug []byte
+    abi AbiType
+    snap []byte
+    profile *profileType
+    cover *coverType
+    debug *debuggerType
+    version uint64
+    height uint64
+    prev *poolType
+    retired bool
+    drain chan struct{}
+    swap chan struct{}
+    policy RecyclePolicyType
+    stats *poolStatsType
 }
 
+////////////////////////////////
+type poolStatsType [poolRecycleNum]atomic.Uint64
+
 ////////////////////////////////
 type runtimeType struct {
     sync.Mutex
     cfg *ConfigType
     poolMap map[string]*poolType
+    loadMap map[string]*poolLoadType
+    evict sync.Mutex
+    numState atomic.Int64
+    memState atomic.Int64
 }
 
+////////////////////////////////
+type DataStateType map[string]any
+
 ////////////////////////////////
 type DataSessionType struct {
     Block map[string]string
@@ -43,6 +121,7 @@ type DataSessionType struct {
     Op map[string]string
     OpParams map[string]string
     State map[string]map[string]string
+    StateTree DataStateType
     ExData map[string]string
 }
 
@@ -53,7 +132,9 @@ type DataResultType struct {
     OpRules map[string]string
     KeyRules map[string]string
     State map[string]map[string]string
+    StateTree DataStateType
     ExData map[string]string
+    Log string
 }
 
 ////////////////////////////////
@@ -68,4 +149,5 @@ type DataCallFuncType struct {
 type dataCallSlotType struct {
     list []int
     keyRules map[string]string
+    keyPrefix map[string]string
 }